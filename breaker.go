@@ -0,0 +1,222 @@
+package sendremotefile
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// idleHostTTL is the time after which the unused per-host state is evicted
+const idleHostTTL time.Duration = 10 * time.Minute
+
+type breakerState int
+
+const (
+	stateClosed breakerState = iota
+	stateHalfOpen
+	stateOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case stateClosed:
+		return "closed"
+	case stateHalfOpen:
+		return "half-open"
+	case stateOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// hostBreaker is the circuit of a single upstream host
+type hostBreaker struct {
+	state breakerState
+	// consecutive failures
+	failures int
+	// requests and failures in the current window, for the error rate
+	total       int
+	failed      int
+	windowStart time.Time
+	openedAt    time.Time
+	// a half-open circuit lets only one request through
+	probing bool
+	// lastUsed is the time of the last request, the idle closed circuits are evicted
+	lastUsed time.Time
+}
+
+// breakers holds the per-host circuit breakers. The hosts come from the worker URLs, so the idle closed
+// circuits are evicted to bound the memory and the circuit_state metric cardinality.
+type breakers struct {
+	mu      sync.Mutex
+	cfg     *CircuitBreakerConfig
+	log     *zap.Logger
+	metrics *statsExporter
+	hosts   map[string]*hostBreaker
+	idleTTL time.Duration
+	sweptAt time.Time
+}
+
+func newBreakers(cfg *CircuitBreakerConfig, log *zap.Logger, metrics *statsExporter) *breakers {
+	return &breakers{
+		cfg:     cfg,
+		log:     log,
+		metrics: metrics,
+		hosts:   make(map[string]*hostBreaker),
+		// the error rate window must not be lost
+		idleTTL: max(idleHostTTL, cfg.Window),
+		sweptAt: time.Now(),
+	}
+}
+
+// allow reports whether a request to the host may be sent, if not, the second value is the time until the next probe
+func (b *breakers) allow(host string) (bool, time.Duration) {
+	if !b.cfg.Enabled {
+		return true, 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	hb := b.get(host)
+
+	switch hb.state {
+	case stateOpen:
+		wait := b.cfg.OpenTimeout - time.Since(hb.openedAt)
+		if wait > 0 {
+			return false, wait
+		}
+
+		b.setState(host, hb, stateHalfOpen)
+		hb.probing = true
+		return true, 0
+	case stateHalfOpen:
+		if hb.probing {
+			return false, b.cfg.OpenTimeout
+		}
+
+		hb.probing = true
+		return true, 0
+	default:
+		return true, 0
+	}
+}
+
+// report records the result of the request allowed by the allow
+func (b *breakers) report(host string, success bool) {
+	if !b.cfg.Enabled {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	hb := b.get(host)
+
+	if time.Since(hb.windowStart) > b.cfg.Window {
+		hb.windowStart = time.Now()
+		hb.total = 0
+		hb.failed = 0
+	}
+
+	hb.total++
+
+	if success {
+		hb.failures = 0
+		if hb.state == stateHalfOpen {
+			hb.probing = false
+			hb.total = 0
+			hb.failed = 0
+			b.setState(host, hb, stateClosed)
+		}
+
+		return
+	}
+
+	hb.failures++
+	hb.failed++
+
+	switch hb.state {
+	case stateHalfOpen:
+		hb.probing = false
+		b.open(host, hb)
+	case stateClosed:
+		if hb.failures >= b.cfg.ConsecutiveFailures {
+			b.open(host, hb)
+			return
+		}
+
+		if b.cfg.ErrorRate > 0 && hb.total >= b.cfg.MinRequests && float64(hb.failed)/float64(hb.total) >= b.cfg.ErrorRate {
+			b.open(host, hb)
+		}
+	default:
+	}
+}
+
+// release lets the next probe through when the allowed request finished without a verdict
+func (b *breakers) release(host string) {
+	if !b.cfg.Enabled {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.get(host).probing = false
+}
+
+func (b *breakers) get(host string) *hostBreaker {
+	now := time.Now()
+	if now.Sub(b.sweptAt) >= b.idleTTL {
+		b.sweep(now)
+	}
+
+	hb, ok := b.hosts[host]
+	if !ok {
+		hb = &hostBreaker{
+			windowStart: now,
+		}
+		b.hosts[host] = hb
+	}
+
+	hb.lastUsed = now
+
+	return hb
+}
+
+// sweep evicts the closed circuits idle for the idleTTL, the open and the probing ones are kept
+func (b *breakers) sweep(now time.Time) {
+	b.sweptAt = now
+
+	for host, hb := range b.hosts {
+		if hb.state == stateClosed && !hb.probing && now.Sub(hb.lastUsed) >= b.idleTTL {
+			delete(b.hosts, host)
+			b.metrics.circuitState.DeleteLabelValues(host)
+		}
+	}
+}
+
+func (b *breakers) open(host string, hb *hostBreaker) {
+	hb.openedAt = time.Now()
+	b.setState(host, hb, stateOpen)
+}
+
+func (b *breakers) setState(host string, hb *hostBreaker, state breakerState) {
+	if hb.state == state {
+		return
+	}
+
+	b.log.Warn("upstream circuit state changed",
+		zap.String("host", host),
+		zap.Stringer("from", hb.state),
+		zap.Stringer("to", state),
+		zap.Int("consecutive_failures", hb.failures),
+		zap.Int("window_failed", hb.failed),
+		zap.Int("window_total", hb.total),
+	)
+
+	hb.state = state
+	b.metrics.circuitState.WithLabelValues(host).Set(float64(state))
+}
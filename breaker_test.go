@@ -0,0 +1,156 @@
+package sendremotefile
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func newTestBreakers(cfg *CircuitBreakerConfig) *breakers {
+	cfg.Enabled = true
+	c := &Config{CircuitBreaker: cfg}
+	c.InitDefaults()
+
+	return newBreakers(c.CircuitBreaker, zap.NewNop(), newStatsExporter())
+}
+
+func TestBreakers(t *testing.T) {
+	const host = "storage:443"
+
+	type step struct {
+		// report is the result of the allowed request, nil skips the request
+		report *bool
+		// wait moves the circuit open time back
+		wait    time.Duration
+		allowed bool
+		state   breakerState
+	}
+
+	ok, fail := true, false
+
+	tests := []struct {
+		name  string
+		cfg   *CircuitBreakerConfig
+		steps []step
+	}{
+		{
+			name: "consecutive failures open the circuit",
+			cfg:  &CircuitBreakerConfig{ConsecutiveFailures: 2},
+			steps: []step{
+				{report: &fail, allowed: true, state: stateClosed},
+				{report: &fail, allowed: true, state: stateOpen},
+				{allowed: false, state: stateOpen},
+			},
+		},
+		{
+			name: "success resets the consecutive failures",
+			cfg:  &CircuitBreakerConfig{ConsecutiveFailures: 2},
+			steps: []step{
+				{report: &fail, allowed: true, state: stateClosed},
+				{report: &ok, allowed: true, state: stateClosed},
+				{report: &fail, allowed: true, state: stateClosed},
+			},
+		},
+		{
+			name: "half-open probe success closes the circuit",
+			cfg:  &CircuitBreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Minute},
+			steps: []step{
+				{report: &fail, allowed: true, state: stateOpen},
+				{wait: time.Minute, report: &ok, allowed: true, state: stateClosed},
+			},
+		},
+		{
+			name: "half-open probe failure opens the circuit again",
+			cfg:  &CircuitBreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Minute},
+			steps: []step{
+				{report: &fail, allowed: true, state: stateOpen},
+				{wait: time.Minute, report: &fail, allowed: true, state: stateOpen},
+				{allowed: false, state: stateOpen},
+			},
+		},
+		{
+			name: "half-open lets only one probe through",
+			cfg:  &CircuitBreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Minute},
+			steps: []step{
+				{report: &fail, allowed: true, state: stateOpen},
+				{wait: time.Minute, allowed: true, state: stateHalfOpen},
+				{allowed: false, state: stateHalfOpen},
+			},
+		},
+		{
+			name: "error rate opens the circuit",
+			cfg:  &CircuitBreakerConfig{ConsecutiveFailures: 100, ErrorRate: 0.5, MinRequests: 4},
+			steps: []step{
+				{report: &fail, allowed: true, state: stateClosed},
+				{report: &ok, allowed: true, state: stateClosed},
+				{report: &ok, allowed: true, state: stateClosed},
+				{report: &fail, allowed: true, state: stateOpen},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBreakers(tt.cfg)
+
+			for i, s := range tt.steps {
+				if s.wait > 0 {
+					b.hosts[host].openedAt = b.hosts[host].openedAt.Add(-s.wait)
+				}
+
+				allowed, _ := b.allow(host)
+				assert.Equal(t, s.allowed, allowed, "step %d", i)
+
+				if allowed && s.report != nil {
+					b.report(host, *s.report)
+				}
+
+				assert.Equal(t, s.state, b.hosts[host].state, "step %d", i)
+			}
+		})
+	}
+}
+
+func TestBreakersDisabled(t *testing.T) {
+	b := newBreakers(&CircuitBreakerConfig{}, zap.NewNop(), newStatsExporter())
+
+	for range 10 {
+		b.report("storage", false)
+	}
+
+	allowed, _ := b.allow("storage")
+	assert.True(t, allowed)
+	assert.Empty(t, b.hosts)
+}
+
+func TestBreakersEviction(t *testing.T) {
+	b := newTestBreakers(&CircuitBreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Minute})
+	b.idleTTL = time.Hour
+
+	// closed -> open -> half-open -> closed sets the gauge
+	b.allow("closed")
+	b.report("closed", false)
+	b.hosts["closed"].openedAt = b.hosts["closed"].openedAt.Add(-time.Minute)
+	b.allow("closed")
+	b.report("closed", true)
+
+	b.allow("open")
+	b.report("open", false)
+
+	assert.Equal(t, 2, testutil.CollectAndCount(b.metrics.circuitState))
+
+	for _, hb := range b.hosts {
+		hb.lastUsed = hb.lastUsed.Add(-2 * time.Hour)
+	}
+	b.sweptAt = b.sweptAt.Add(-2 * time.Hour)
+
+	b.allow("new")
+
+	assert.NotContains(t, b.hosts, "closed")
+	assert.Contains(t, b.hosts, "open")
+	assert.Contains(t, b.hosts, "new")
+	assert.Equal(t, 1, testutil.CollectAndCount(b.metrics.circuitState))
+}
@@ -0,0 +1,116 @@
+package sendremotefile
+
+import (
+	"bytes"
+	"crypto/md5" //nolint:gosec
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	xSendRemoteChecksumHeader string = "X-Sendremotefile-Checksum"
+	amzChecksumSHA256Header   string = "X-Amz-Checksum-Sha256"
+	contentMD5Header          string = "Content-Md5"
+)
+
+// checksum algorithms
+const (
+	checksumSHA256 string = "sha256"
+	checksumMD5    string = "md5"
+)
+
+// checksum is the expected digest of the upstream body, verified while streaming
+type checksum struct {
+	algo     string
+	expected []byte
+	// source is the worker or the upstream header name, for the logs
+	source string
+	h      hash.Hash
+}
+
+// parseChecksum parses the `<algo>=<hex>` value, e.g. `sha256=9f86d0...`
+func parseChecksum(value string) (*checksum, error) {
+	algo, sum, ok := strings.Cut(strings.TrimSpace(value), "=")
+	if !ok {
+		return nil, errors.New("checksum must be <algo>=<hex>")
+	}
+
+	expected, err := hex.DecodeString(sum)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checksum hex: %w", err)
+	}
+
+	return newChecksum(strings.ToLower(algo), expected, "worker")
+}
+
+// upstreamChecksum returns the checksum from the upstream response headers, nil if there is no usable one.
+// S3 composite (multipart) checksums can't be verified over the whole body and are ignored.
+func upstreamChecksum(h http.Header) *checksum {
+	if v := h.Get(amzChecksumSHA256Header); v != "" {
+		if sum, err := base64.StdEncoding.DecodeString(v); err == nil {
+			if c, err := newChecksum(checksumSHA256, sum, amzChecksumSHA256Header); err == nil {
+				return c
+			}
+		}
+	}
+
+	if v := h.Get(contentMD5Header); v != "" {
+		if sum, err := base64.StdEncoding.DecodeString(v); err == nil {
+			if c, err := newChecksum(checksumMD5, sum, contentMD5Header); err == nil {
+				return c
+			}
+		}
+	}
+
+	return nil
+}
+
+func newChecksum(algo string, expected []byte, source string) (*checksum, error) {
+	var h hash.Hash
+	switch algo {
+	case checksumSHA256:
+		h = sha256.New()
+	case checksumMD5:
+		h = md5.New() //nolint:gosec
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q, expected sha256 or md5", algo)
+	}
+
+	if len(expected) != h.Size() {
+		return nil, fmt.Errorf("invalid %s checksum length %d", algo, len(expected))
+	}
+
+	return &checksum{algo: algo, expected: expected, source: source, h: h}, nil
+}
+
+// verifyChecksum aborts the transfer whose body does not match the expected checksum
+func (p *Plugin) verifyChecksum(t *transfer) {
+	c := t.checksum
+	if c == nil {
+		return
+	}
+
+	actual := c.h.Sum(nil)
+	if bytes.Equal(actual, c.expected) {
+		return
+	}
+
+	p.log.Error("checksum mismatch, aborting",
+		p.redact.URL("url", t.url),
+		zap.String("algorithm", c.algo),
+		zap.String("source", c.source),
+		zap.String("expected", hex.EncodeToString(c.expected)),
+		zap.String("actual", hex.EncodeToString(actual)),
+		zap.Int64("written", t.written),
+	)
+	t.outcome = outcomeChecksumMismatch
+	abort()
+}
@@ -0,0 +1,98 @@
+package sendremotefile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5" //nolint:gosec
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChecksum(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		algo  string
+		err   bool
+	}{
+		{name: "sha256", value: "sha256=b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", algo: checksumSHA256},
+		{name: "md5 upper case algorithm", value: "MD5=5eb63bbbe01eeed093cb22bb8f5acdc3", algo: checksumMD5},
+		{name: "no separator", value: "b94d27b9934d3e08", err: true},
+		{name: "unknown algorithm", value: "sha1=2aae6c35c94fcfb415dbe95f408b9ce91ee846ed", err: true},
+		{name: "invalid hex", value: "md5=zz", err: true},
+		{name: "wrong length", value: "sha256=5eb63bbbe01eeed093cb22bb8f5acdc3", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseChecksum(tt.value)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.algo, c.algo)
+		})
+	}
+}
+
+func TestUpstreamChecksum(t *testing.T) {
+	sum := md5.Sum([]byte("hello world")) //nolint:gosec
+
+	tests := []struct {
+		name   string
+		header http.Header
+		source string
+	}{
+		{name: "none", header: http.Header{}},
+		{name: "content md5", header: http.Header{contentMD5Header: {base64.StdEncoding.EncodeToString(sum[:])}}, source: contentMD5Header},
+		{name: "s3 composite checksum is ignored", header: http.Header{amzChecksumSHA256Header: {"ZGVhZGJlZWY=-3"}}},
+		{name: "wrong length is ignored", header: http.Header{contentMD5Header: {"ZGVhZGJlZWY="}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := upstreamChecksum(tt.header)
+			if tt.source == "" {
+				assert.Nil(t, c)
+				return
+			}
+
+			require.NotNil(t, c)
+			assert.Equal(t, tt.source, c.source)
+		})
+	}
+}
+
+// the transport asks for gzip on its own and decompresses the body, the Content-MD5 is about the gzip bytes
+func TestUpstreamChecksumTransparentGzip(t *testing.T) {
+	body := strings.Repeat("0123456789", 1000)
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, err := zw.Write([]byte(body))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	sum := md5.Sum(gz.Bytes()) //nolint:gosec
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "gzip", r.Header.Get("Accept-Encoding"))
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		_, _ = w.Write(gz.Bytes())
+	}))
+	defer upstream.Close()
+
+	p := newTestPlugin(t, &Config{})
+	rec := serve(p, http.Header{xSendRemoteHeader: {upstream.URL}}, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, body, rec.Body.String())
+}
@@ -2,14 +2,19 @@ package sendremotefile
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"net/http"
+	"net/url"
 	"time"
+
+	"go.opentelemetry.io/otel/propagation"
 )
 
 type client struct {
-	inner *http.Client
-	url   string
+	inner  *http.Client
+	url    string
+	header http.Header
 }
 
 type connection struct {
@@ -17,35 +22,110 @@ type connection struct {
 	timeout time.Duration
 }
 
-func NewClient(url string, timeout time.Duration) *client {
-	return &client{
-		inner: &http.Client{
-			Transport: &http.Transport{
-				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-					conn, err := (&net.Dialer{Timeout: timeout}).DialContext(ctx, network, addr)
-					if err != nil {
-						return nil, err
-					}
+// upstreamHost returns the host (with port, if any) of the upstream URL, empty for the malformed URLs
+func upstreamHost(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+
+	return u.Host
+}
+
+// clientOptions are the upstream client policies shared by all the requests
+type clientOptions struct {
+	// redirects is the redirects policy, nil keeps the net/http one
+	redirects *RedirectsConfig
+	// tls are the per host TLS configs
+	tls map[string]*tls.Config
+	// hosts restricts the upstream hosts and addresses, nil allows all
+	hosts *hostPolicy
+}
+
+// NewClient creates the upstream client, the nil options keep the net/http defaults.
+// The TLS config is picked per dialed host, so the redirects to another host get their own one.
+func NewClient(url string, timeout time.Duration, header http.Header, opts *clientOptions) *client {
+	if opts == nil {
+		opts = &clientOptions{}
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	if opts.hosts != nil {
+		dialer.Control = opts.hosts.control
+	}
+
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		return &connection{
+			Conn:    conn,
+			timeout: timeout,
+		}, nil
+	}
 
-					return &connection{
-						Conn:    conn,
-						timeout: timeout,
-					}, nil
-				},
-				TLSHandshakeTimeout:   timeout,
-				ResponseHeaderTimeout: timeout,
-			},
-		},
-		url: url,
+	transport := &http.Transport{
+		DialContext:           dial,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
 	}
+
+	if len(opts.tls) > 0 {
+		transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+			if tc := lookupHost(opts.tls, addr); tc != nil {
+				cfg = tc.Clone()
+			}
+
+			if cfg.ServerName == "" {
+				cfg.ServerName, _, _ = net.SplitHostPort(addr)
+			}
+
+			conn, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+
+			// the TLSHandshakeTimeout does not apply to the custom TLS dial
+			hctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			tc := tls.Client(conn, cfg)
+			if err := tc.HandshakeContext(hctx); err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+
+			return tc, nil
+		}
+	}
+
+	c := &client{
+		inner:  &http.Client{Transport: transport},
+		url:    url,
+		header: header,
+	}
+
+	if opts.redirects != nil {
+		c.inner.CheckRedirect = checkRedirect(opts.redirects, opts.hosts, header)
+	}
+
+	return c
 }
 
-func (c *client) Request() (*http.Response, error) {
-	req, err := http.NewRequest(http.MethodGet, c.url, nil)
+func (c *client) Request(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	setUpstreamHeaders(req, c.header)
+
+	// propagate the upstream fetch span via the W3C traceparent header
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
 	return c.inner.Do(req)
 }
 
@@ -57,3 +137,13 @@ func (c *connection) Read(b []byte) (int, error) {
 
 	return c.Conn.Read(b)
 }
+
+// Probe sends a HEAD request to check the upstream reachability
+func (c *client) Probe(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.inner.Do(req)
+}
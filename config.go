@@ -0,0 +1,510 @@
+package sendremotefile
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var statusKeyRe = regexp.MustCompile(`^[1-5](\d\d|xx)$`)
+
+// Config is the sendremotefile middleware configuration, `http.sendremotefile` section
+type Config struct {
+	// RedactQueryParams is the list of the upstream URL query parameters (case-insensitive)
+	// which values are hidden in the logs. `*` hides all query parameters.
+	RedactQueryParams []string `mapstructure:"redact_query_params"`
+	// LogFullURLs disables the URL redaction in the logs, for development only
+	LogFullURLs bool `mapstructure:"log_full_urls"`
+	// TransferLog configures the per-transfer summary log entry
+	TransferLog *TransferLogConfig `mapstructure:"transfer_log"`
+	// ServerTiming adds the Server-Timing (header and trailer) and X-Sendremotefile-Cache headers
+	ServerTiming bool `mapstructure:"server_timing"`
+	// Digest adds the RFC 9530 Repr-Digest and Content-Digest (SHA-256) headers, or trailers when the digest
+	// is not known before streaming
+	Digest bool `mapstructure:"digest"`
+	// Health configures the readiness probes of the upstreams
+	Health *HealthConfig `mapstructure:"health"`
+	// CircuitBreaker configures the per upstream host circuit breakers
+	CircuitBreaker *CircuitBreakerConfig `mapstructure:"circuit_breaker"`
+	// Limits configures the upstream fetches concurrency
+	Limits *LimitsConfig `mapstructure:"limits"`
+	// Throttle configures the bandwidth limits
+	Throttle *ThrottleConfig `mapstructure:"throttle"`
+	// SlowClient configures the protection against the slow downstream clients
+	SlowClient *SlowClientConfig `mapstructure:"slow_client"`
+	// Failover configures the fallback to the mirror URLs
+	Failover *FailoverConfig `mapstructure:"failover"`
+	// Hedging configures the hedged upstream requests
+	Hedging *HedgingConfig `mapstructure:"hedging"`
+	// Redirects configures the upstream redirects policy
+	Redirects *RedirectsConfig `mapstructure:"redirects"`
+	// Hosts restricts the upstream hosts and addresses, for the URLs, the redirects and the probes
+	Hosts *HostsConfig `mapstructure:"hosts"`
+	// TLS configures the upstream TLS, per host (`host` or `host:port`) or `*` for the rest
+	TLS map[string]*TLSConfig `mapstructure:"tls"`
+	// StatusMap maps the upstream status codes (`404`) or classes (`4xx`) to the client response status codes.
+	// The entries are merged with the defaults, unmapped statuses become 502.
+	StatusMap map[string]int `mapstructure:"status_map"`
+	// WorkerFallback sends the worker's own response (status, headers and body) instead of the error
+	// when the upstream fetch fails before the streaming begins
+	WorkerFallback bool `mapstructure:"worker_fallback"`
+	// Errors configures the error responses rendering
+	Errors *ErrorsConfig `mapstructure:"errors"`
+	// ContentType configures the Content-Type of the served files
+	ContentType *ContentTypeConfig `mapstructure:"content_type"`
+	// Options configures the X-Sendremotefile-Options header
+	Options *OptionsConfig `mapstructure:"options"`
+	// UpstreamHeaders are the static headers sent to the upstream, per host (`host` or `host:port`) or `*` for the rest
+	UpstreamHeaders map[string]map[string]string `mapstructure:"upstream_headers"`
+	// ForwardWorkerHeaders are the header names the worker may send to the upstream
+	// with the X-Sendremotefile-Upstream-<Name> headers or the X-Sendremotefile-Options headers option
+	ForwardWorkerHeaders []string `mapstructure:"forward_worker_headers"`
+	// ForwardRequestHeaders are the client request headers copied to the upstream request, e.g. Accept-Language.
+	// With Accept-Encoding, the upstream Content-Encoding is passed to the client as is. Host is not allowed.
+	ForwardRequestHeaders []string `mapstructure:"forward_request_headers"`
+	// ForwardingHeaders adds the Forwarded, X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host headers
+	ForwardingHeaders bool `mapstructure:"forwarding_headers"`
+	// MaxObjectSize rejects the upstream objects larger than it, e.g. `5GB`. The chunked bodies are aborted
+	// when the limit is passed. Unlimited by default
+	MaxObjectSize string `mapstructure:"max_object_size"`
+	// AllowedContentTypes rejects the upstream responses with other content types, `image/*` matches any image.
+	// All types are allowed by default
+	AllowedContentTypes []string `mapstructure:"allowed_content_types"`
+}
+
+type OptionsConfig struct {
+	// Allowed are the options the worker may set: urls, headers, filename, content_type, disposition,
+	// cache_control, rate, timeout and checksum. Default: urls, filename, content_type, disposition, cache_control,
+	// rate and checksum
+	Allowed []string `mapstructure:"allowed"`
+}
+
+type ContentTypeConfig struct {
+	// Mode is one of: forced (default), upstream, worker or sniff. The worker may override it
+	// with the X-Sendremotefile-Content-Type header.
+	Mode string `mapstructure:"mode"`
+	// Forced is the content type for the forced mode and the fallback, default application/octet-stream
+	Forced string `mapstructure:"forced"`
+}
+
+type ErrorsConfig struct {
+	// ProblemJSON sends the RFC 9457 application/problem+json errors to the clients accepting JSON
+	ProblemJSON bool `mapstructure:"problem_json"`
+	// Templates are the html/template files per status code (e.g. `404: /etc/rr/404.html`) for the other clients
+	Templates map[string]string `mapstructure:"templates"`
+}
+
+type TransferLogConfig struct {
+	// Enabled turns on the info level summary entry for every finished transfer
+	Enabled bool `mapstructure:"enabled"`
+	// SampleRate is the fraction (0, 1] of the transfers to log, default 1
+	SampleRate float64 `mapstructure:"sample_rate"`
+}
+
+type HealthConfig struct {
+	// ProbeURLs are checked with a HEAD request, the plugin is not ready if any of them is unreachable
+	ProbeURLs []string `mapstructure:"probe_urls"`
+	// ProbeTimeout is the timeout of a single probe, default 2s
+	ProbeTimeout time.Duration `mapstructure:"probe_timeout"`
+	// CacheTTL is how long the probe results are reused, default 10s
+	CacheTTL time.Duration `mapstructure:"cache_ttl"`
+	// FailureThreshold is the number of consecutive failed probes to open the circuit, default 1
+	FailureThreshold int `mapstructure:"failure_threshold"`
+}
+
+type CircuitBreakerConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// ConsecutiveFailures opens the circuit after N failed requests in a row, default 5
+	ConsecutiveFailures int `mapstructure:"consecutive_failures"`
+	// ErrorRate (0, 1] opens the circuit when the share of the failed requests in the window reaches it, disabled by default
+	ErrorRate float64 `mapstructure:"error_rate"`
+	// MinRequests is the minimum number of the requests in the window to apply the ErrorRate, default 20
+	MinRequests int `mapstructure:"min_requests"`
+	// Window is the error rate measurement window, default 60s
+	Window time.Duration `mapstructure:"window"`
+	// OpenTimeout is the time after which the open circuit lets a probe request through (half-open), default 30s
+	OpenTimeout time.Duration `mapstructure:"open_timeout"`
+}
+
+type LimitsConfig struct {
+	// MaxInFlight is the maximum number of the simultaneous transfers, 0 is unlimited
+	MaxInFlight int `mapstructure:"max_in_flight"`
+	// MaxInFlightPerHost is the maximum number of the simultaneous transfers per upstream host, 0 is unlimited
+	MaxInFlightPerHost int `mapstructure:"max_in_flight_per_host"`
+	// MaxQueue is the maximum number of the transfers waiting for a free slot, 0 rejects immediately
+	MaxQueue int `mapstructure:"max_queue"`
+	// QueueTimeout is the maximum time to wait for a free slot, for all the upstream URLs of the transfer, default 5s
+	QueueTimeout time.Duration `mapstructure:"queue_timeout"`
+	// RetryAfter is sent to the rejected clients, default 1s
+	RetryAfter time.Duration `mapstructure:"retry_after"`
+}
+
+type ThrottleConfig struct {
+	// Global is the bandwidth cap (bytes/sec) of all transfers, e.g. `100MB`, unlimited by default
+	Global string `mapstructure:"global"`
+	// PerTransfer is the bandwidth cap (bytes/sec) of a single transfer, e.g. `5MB`, unlimited by default.
+	// The worker may lower it for a response with the X-Sendremotefile-Rate header.
+	PerTransfer string `mapstructure:"per_transfer"`
+}
+
+type SlowClientConfig struct {
+	// WriteTimeout is the deadline of every write to the client, disabled by default
+	WriteTimeout time.Duration `mapstructure:"write_timeout"`
+	// MinThroughput is the minimum average transfer rate (bytes/sec) after the GracePeriod, e.g. `16KB`, disabled by default.
+	// Keep it below the throttle rates, otherwise the throttled transfers are aborted.
+	MinThroughput string `mapstructure:"min_throughput"`
+	// GracePeriod is the time before the MinThroughput is checked, default 10s
+	GracePeriod time.Duration `mapstructure:"grace_period"`
+	// MaxDuration is the maximum total duration of a transfer, it also bounds the blocked writes and the throttling
+	// waits, disabled by default
+	MaxDuration time.Duration `mapstructure:"max_duration"`
+}
+
+type FailoverConfig struct {
+	// RetryableStatuses are the upstream status codes after which the next mirror URL is tried,
+	// default 408, 429, 500, 502, 503, 504
+	RetryableStatuses []int `mapstructure:"retryable_statuses"`
+}
+
+type HostsConfig struct {
+	// Allowed are the upstream hosts (`storage.example.com` or `*.example.com`), all are allowed by default
+	Allowed []string `mapstructure:"allowed"`
+	// DenyPrivate rejects the loopback, private, link-local and unspecified addresses, checked when connecting
+	DenyPrivate bool `mapstructure:"deny_private"`
+	// AllowedNetworks are the CIDRs allowed despite the DenyPrivate, e.g. 10.1.0.0/16
+	AllowedNetworks []string `mapstructure:"allowed_networks"`
+}
+
+type TLSConfig struct {
+	// RootCA are the PEM files with the CA certificates to verify the upstream with, the system ones by default
+	RootCA []string `mapstructure:"root_ca"`
+	// Cert and Key are the PEM client certificate and key files, reloaded when changed
+	Cert string `mapstructure:"cert"`
+	Key  string `mapstructure:"key"`
+	// ServerName overrides the server name sent (SNI) and verified
+	ServerName string `mapstructure:"server_name"`
+	// MinVersion is one of 1.0, 1.1, 1.2 or 1.3, default 1.2
+	MinVersion string `mapstructure:"min_version"`
+	// CipherSuites are the TLS 1.2 cipher suite names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, the Go defaults by default.
+	// The TLS 1.3 cipher suites are not configurable
+	CipherSuites []string `mapstructure:"cipher_suites"`
+	// InsecureSkipVerify disables the upstream certificate verification, for development only
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"`
+}
+
+type RedirectsConfig struct {
+	// Disabled passes the upstream redirect responses as the upstream errors
+	Disabled bool `mapstructure:"disabled"`
+	// Max is the maximum number of the followed redirects, default 10
+	Max int `mapstructure:"max"`
+	// SameHost follows only the redirects to the same host (with port)
+	SameHost bool `mapstructure:"same_host"`
+	// CrossHostHeaders are the configured, worker and forwarded client headers kept on the redirects to
+	// another host, the rest are dropped
+	CrossHostHeaders []string `mapstructure:"cross_host_headers"`
+}
+
+type HedgingConfig struct {
+	// Enabled sends a second request to the next mirror (or the same URL) if the first one is slow
+	Enabled bool `mapstructure:"enabled"`
+	// Delay is the time to wait for the response headers before hedging, default 100ms
+	Delay time.Duration `mapstructure:"delay"`
+	// Percentile (0, 1) of the recent upstream TTFBs used as the delay instead of the fixed one, e.g. 0.95
+	Percentile float64 `mapstructure:"percentile"`
+	// MinSamples is the number of the TTFB samples required to use the Percentile, default 100
+	MinSamples int `mapstructure:"min_samples"`
+}
+
+func (c *Config) InitDefaults() {
+	if c.Options == nil {
+		c.Options = &OptionsConfig{}
+	}
+
+	if c.Options.Allowed == nil {
+		c.Options.Allowed = []string{
+			optionURLs,
+			optionFilename,
+			optionContentType,
+			optionDisposition,
+			optionCacheControl,
+			optionRate,
+			optionChecksum,
+		}
+	}
+
+	if c.ContentType == nil {
+		c.ContentType = &ContentTypeConfig{}
+	}
+
+	if c.ContentType.Mode == "" {
+		c.ContentType.Mode = contentTypeForced
+	}
+
+	if c.ContentType.Forced == "" {
+		c.ContentType.Forced = responseContentTypeVal
+	}
+
+	if c.Errors == nil {
+		c.Errors = &ErrorsConfig{}
+	}
+
+	defaultStatusMap := map[string]int{
+		"404": http.StatusNotFound,
+		"410": http.StatusGone,
+		// expired or invalid presigned URL is not the client's fault
+		"401": http.StatusBadGateway,
+		"403": http.StatusBadGateway,
+		"408": http.StatusGatewayTimeout,
+		"4xx": http.StatusBadGateway,
+		"504": http.StatusGatewayTimeout,
+		"5xx": http.StatusBadGateway,
+	}
+
+	if c.StatusMap == nil {
+		c.StatusMap = make(map[string]int, len(defaultStatusMap))
+	}
+
+	for k, v := range defaultStatusMap {
+		if _, ok := c.StatusMap[k]; !ok {
+			c.StatusMap[k] = v
+		}
+	}
+
+	if c.Hedging == nil {
+		c.Hedging = &HedgingConfig{}
+	}
+
+	if c.Hedging.Delay == 0 {
+		c.Hedging.Delay = 100 * time.Millisecond
+	}
+
+	if c.Hedging.MinSamples == 0 {
+		c.Hedging.MinSamples = 100
+	}
+
+	if c.Failover == nil {
+		c.Failover = &FailoverConfig{}
+	}
+
+	if len(c.Failover.RetryableStatuses) == 0 {
+		c.Failover.RetryableStatuses = []int{
+			http.StatusRequestTimeout,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		}
+	}
+
+	if c.Redirects == nil {
+		c.Redirects = &RedirectsConfig{}
+	}
+
+	if c.Hosts == nil {
+		c.Hosts = &HostsConfig{}
+	}
+
+	if c.Redirects.Max == 0 {
+		c.Redirects.Max = 10
+	}
+
+	if c.SlowClient == nil {
+		c.SlowClient = &SlowClientConfig{}
+	}
+
+	if c.SlowClient.GracePeriod == 0 {
+		c.SlowClient.GracePeriod = 10 * time.Second
+	}
+
+	if c.Throttle == nil {
+		c.Throttle = &ThrottleConfig{}
+	}
+
+	if c.Limits == nil {
+		c.Limits = &LimitsConfig{}
+	}
+
+	if c.Limits.QueueTimeout == 0 {
+		c.Limits.QueueTimeout = 5 * time.Second
+	}
+
+	if c.Limits.RetryAfter == 0 {
+		c.Limits.RetryAfter = time.Second
+	}
+
+	if c.CircuitBreaker == nil {
+		c.CircuitBreaker = &CircuitBreakerConfig{}
+	}
+
+	if c.CircuitBreaker.ConsecutiveFailures == 0 {
+		c.CircuitBreaker.ConsecutiveFailures = 5
+	}
+
+	if c.CircuitBreaker.MinRequests == 0 {
+		c.CircuitBreaker.MinRequests = 20
+	}
+
+	if c.CircuitBreaker.Window == 0 {
+		c.CircuitBreaker.Window = time.Minute
+	}
+
+	if c.CircuitBreaker.OpenTimeout == 0 {
+		c.CircuitBreaker.OpenTimeout = 30 * time.Second
+	}
+
+	if c.Health == nil {
+		c.Health = &HealthConfig{}
+	}
+
+	if c.Health.ProbeTimeout == 0 {
+		c.Health.ProbeTimeout = 2 * time.Second
+	}
+
+	if c.Health.CacheTTL == 0 {
+		c.Health.CacheTTL = 10 * time.Second
+	}
+
+	if c.Health.FailureThreshold == 0 {
+		c.Health.FailureThreshold = 1
+	}
+
+	if c.TransferLog == nil {
+		c.TransferLog = &TransferLogConfig{}
+	}
+
+	if c.TransferLog.SampleRate <= 0 || c.TransferLog.SampleRate > 1 {
+		c.TransferLog.SampleRate = 1
+	}
+
+	if len(c.RedactQueryParams) == 0 {
+		c.RedactQueryParams = []string{
+			// AWS S3 (SigV4/SigV2) and S3-compatible storages
+			"X-Amz-Signature",
+			"X-Amz-Credential",
+			"X-Amz-Security-Token",
+			"AWSAccessKeyId",
+			"Signature",
+			// Google Cloud Storage
+			"X-Goog-Signature",
+			"X-Goog-Credential",
+			"GoogleAccessId",
+			// Azure Blob Storage SAS
+			"sig",
+			// generic tokens
+			"token",
+			"access_token",
+		}
+	}
+}
+
+func (c *Config) Valid() error {
+	for host, headers := range c.UpstreamHeaders {
+		for k, v := range headers {
+			if !validHeader(k, v) {
+				return fmt.Errorf("invalid upstream_headers.%s header %q", host, k)
+			}
+		}
+	}
+
+	for _, k := range c.ForwardWorkerHeaders {
+		if !validHeader(k, "") {
+			return fmt.Errorf("invalid forward_worker_headers header name %q", k)
+		}
+	}
+
+	for _, k := range c.ForwardRequestHeaders {
+		if !validHeader(k, "") {
+			return fmt.Errorf("invalid forward_request_headers header name %q", k)
+		}
+
+		// net/http moves it to the request Host, the forwarding_headers send it as X-Forwarded-Host
+		if http.CanonicalHeaderKey(k) == "Host" {
+			return fmt.Errorf("forward_request_headers can't forward %q, use forwarding_headers instead", k)
+		}
+	}
+
+	if c.Hedging.Percentile < 0 || c.Hedging.Percentile >= 1 {
+		return fmt.Errorf("invalid hedging.percentile %v, expected a fraction in (0, 1), e.g. 0.95", c.Hedging.Percentile)
+	}
+
+	if c.Redirects.Max < 0 {
+		return fmt.Errorf("invalid redirects.max %d, must not be negative", c.Redirects.Max)
+	}
+
+	for _, n := range c.Hosts.AllowedNetworks {
+		if _, _, err := net.ParseCIDR(n); err != nil {
+			return fmt.Errorf("invalid hosts.allowed_networks %q: %w", n, err)
+		}
+	}
+
+	for _, k := range c.Redirects.CrossHostHeaders {
+		if !validHeader(k, "") {
+			return fmt.Errorf("invalid redirects.cross_host_headers header name %q", k)
+		}
+	}
+
+	for host, t := range c.TLS {
+		if t == nil {
+			continue
+		}
+
+		if _, ok := tlsVersions[t.MinVersion]; t.MinVersion != "" && !ok {
+			return fmt.Errorf("invalid tls.%s.min_version %q, expected one of: 1.0, 1.1, 1.2, 1.3", host, t.MinVersion)
+		}
+
+		if (t.Cert == "") != (t.Key == "") {
+			return fmt.Errorf("tls.%s.cert and tls.%s.key must be set together", host, host)
+		}
+
+		if _, err := cipherSuites(t.CipherSuites); err != nil {
+			return fmt.Errorf("invalid tls.%s.cipher_suites: %w", host, err)
+		}
+	}
+
+	for _, a := range c.AllowedContentTypes {
+		if err := validAllowedContentType(a); err != nil {
+			return err
+		}
+	}
+
+	for _, o := range c.Options.Allowed {
+		if !slices.Contains(knownOptions, o) {
+			return fmt.Errorf("unknown option %q in options.allowed", o)
+		}
+	}
+
+	if !validContentTypeMode(c.ContentType.Mode) {
+		return fmt.Errorf("invalid content_type.mode %q, expected one of: forced, upstream, worker, sniff", c.ContentType.Mode)
+	}
+
+	for k, v := range c.StatusMap {
+		if !statusKeyRe.MatchString(k) {
+			return fmt.Errorf("invalid status_map key %q, expected a status code (404) or a class (4xx)", k)
+		}
+
+		if v < 100 || v > 599 {
+			return fmt.Errorf("invalid status_map value %d for the key %q", v, k)
+		}
+	}
+
+	return nil
+}
+
+// downstreamStatus returns the client response status code for the upstream status code
+func (c *Config) downstreamStatus(upstream int) int {
+	code := strconv.Itoa(upstream)
+	if v, ok := c.StatusMap[code]; ok {
+		return v
+	}
+
+	if v, ok := c.StatusMap[code[:1]+"xx"]; ok {
+		return v
+	}
+
+	return http.StatusBadGateway
+}
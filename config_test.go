@@ -0,0 +1,67 @@
+package sendremotefile
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownstreamStatus(t *testing.T) {
+	cfg := &Config{StatusMap: map[string]int{
+		"404": http.StatusGone,
+		"429": http.StatusServiceUnavailable,
+		"4xx": http.StatusBadRequest,
+		"5xx": http.StatusServiceUnavailable,
+	}}
+	cfg.InitDefaults()
+
+	tests := []struct {
+		upstream int
+		status   int
+	}{
+		// the user entries override the defaults
+		{upstream: 404, status: http.StatusGone},
+		{upstream: 429, status: http.StatusServiceUnavailable},
+		{upstream: 418, status: http.StatusBadRequest},
+		{upstream: 500, status: http.StatusServiceUnavailable},
+		// the merged default codes beat the user classes
+		{upstream: 403, status: http.StatusBadGateway},
+		{upstream: 408, status: http.StatusGatewayTimeout},
+		{upstream: 410, status: http.StatusGone},
+		{upstream: 504, status: http.StatusGatewayTimeout},
+		// the unmapped classes, e.g. the redirects when they are disabled
+		{upstream: 302, status: http.StatusBadGateway},
+		{upstream: 304, status: http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.upstream), func(t *testing.T) {
+			assert.Equal(t, tt.status, cfg.downstreamStatus(tt.upstream))
+		})
+	}
+}
+
+func TestDownstreamStatusDefaults(t *testing.T) {
+	cfg := &Config{}
+	cfg.InitDefaults()
+
+	tests := []struct {
+		upstream int
+		status   int
+	}{
+		{upstream: 404, status: http.StatusNotFound},
+		{upstream: 401, status: http.StatusBadGateway},
+		{upstream: 429, status: http.StatusBadGateway},
+		{upstream: 503, status: http.StatusBadGateway},
+		{upstream: 504, status: http.StatusGatewayTimeout},
+		{upstream: 301, status: http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.upstream), func(t *testing.T) {
+			assert.Equal(t, tt.status, cfg.downstreamStatus(tt.upstream))
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package sendremotefile
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// content type modes
+const (
+	// forced sets the configured content type
+	contentTypeForced string = "forced"
+	// upstream uses the upstream response Content-Type
+	contentTypeUpstream string = "upstream"
+	// worker keeps the Content-Type set by the worker
+	contentTypeWorker string = "worker"
+	// sniff detects the content type from the first bytes of the body
+	contentTypeSniff string = "sniff"
+)
+
+const (
+	contentDispositionHeader string = "Content-Disposition"
+	dispositionInline        string = "inline"
+	dispositionAttachment    string = "attachment"
+	// sniffLen is the number of bytes used by the http.DetectContentType
+	sniffLen int = 512
+)
+
+func validContentTypeMode(mode string) bool {
+	switch mode {
+	case contentTypeForced, contentTypeUpstream, contentTypeWorker, contentTypeSniff:
+		return true
+	default:
+		return false
+	}
+}
+
+// contentType returns the response Content-Type for the mode, falling back to the forced one
+// when the chosen source has no content type
+func (p *Plugin) contentType(mode string, workerCT string, upstreamCT string, head []byte) string {
+	var ct string
+	switch mode {
+	case contentTypeUpstream:
+		ct = upstreamCT
+	case contentTypeWorker:
+		ct = workerCT
+	case contentTypeSniff:
+		if len(head) > 0 {
+			ct = http.DetectContentType(head)
+		}
+	}
+
+	if ct == "" {
+		return p.cfg.ContentType.Forced
+	}
+
+	return ct
+}
+
+// contentDisposition builds the RFC 6266 Content-Disposition with the RFC 5987 encoded UTF-8 filename
+// and the ASCII fallback for the old clients
+func contentDisposition(disposition string, filename string) string {
+	if disposition == "" {
+		disposition = dispositionAttachment
+	}
+
+	// only the base name, without the control characters
+	filename = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, filename)
+	filename = path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if filename == "" || filename == "." || filename == "/" {
+		return disposition
+	}
+
+	var fallback strings.Builder
+	for _, r := range filename {
+		switch {
+		case r > 0x7e:
+			fallback.WriteByte('_')
+		case r == '"' || r == '\\':
+			fallback.WriteByte('_')
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+
+	cd := disposition + `; filename="` + fallback.String() + `"`
+	// the fallback differs for the non-ASCII names and the names with the quotes
+	if fallback.String() != filename {
+		cd += "; filename*=UTF-8''" + encodeRFC5987(filename)
+	}
+
+	return cd
+}
+
+// encodeRFC5987 percent-encodes the UTF-8 bytes, except the RFC 5987 attr-char
+func encodeRFC5987(s string) string {
+	const hex = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isAttrChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0x0f])
+	}
+
+	return b.String()
+}
+
+func isAttrChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
@@ -0,0 +1,41 @@
+package sendremotefile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name        string
+		disposition string
+		filename    string
+		cd          string
+	}{
+		{name: "no filename", disposition: dispositionInline, cd: "inline"},
+		{name: "default attachment", filename: "report.pdf", cd: `attachment; filename="report.pdf"`},
+		{name: "inline", disposition: dispositionInline, filename: "a.png", cd: `inline; filename="a.png"`},
+		{name: "path", filename: "../../etc/passwd", cd: `attachment; filename="passwd"`},
+		{name: "windows path", filename: `C:\dir\file.txt`, cd: `attachment; filename="file.txt"`},
+		{name: "directory only", filename: "dir/", cd: `attachment; filename="dir"`},
+		{name: "root", filename: "/", cd: "attachment"},
+		{name: "control characters", filename: "a\r\nb.txt", cd: `attachment; filename="ab.txt"`},
+		{
+			name:     "quotes",
+			filename: `say "hi".txt`,
+			cd:       `attachment; filename="say _hi_.txt"; filename*=UTF-8''say%20%22hi%22.txt`,
+		},
+		{
+			name:     "utf-8",
+			filename: "отчёт.pdf",
+			cd:       `attachment; filename="_____.pdf"; filename*=UTF-8''%D0%BE%D1%82%D1%87%D1%91%D1%82.pdf`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.cd, contentDisposition(tt.disposition, tt.filename))
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package sendremotefile
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+)
+
+const (
+	contentDigestHeader string = "Content-Digest"
+	reprDigestHeader    string = "Repr-Digest"
+)
+
+// setDigest sets the RFC 9530 digest headers when the SHA-256 of the body is known before streaming,
+// otherwise declares them as trailers and starts hashing the body, see setDigestTrailer.
+// The known digest is verified while streaming, so the mismatching transfer is aborted and never completes.
+func setDigest(h http.Header, t *transfer, encoded bool) {
+	// without the ranges, the content and the representation digests are the same
+	if !encoded && t.checksum != nil && t.checksum.algo == checksumSHA256 {
+		v := digestValue(t.checksum.expected)
+		h.Set(reprDigestHeader, v)
+		h.Set(contentDigestHeader, v)
+		return
+	}
+
+	t.digest = sha256.New()
+	h.Add(trailerHeader, reprDigestHeader)
+	h.Add(trailerHeader, contentDigestHeader)
+}
+
+// setDigestTrailer sets the computed digest trailers, should be called after the whole body is written
+func setDigestTrailer(h http.Header, t *transfer) {
+	if t.digest == nil || t.outcome != outcomeServed {
+		return
+	}
+
+	v := digestValue(t.digest.Sum(nil))
+	h.Set(reprDigestHeader, v)
+	h.Set(contentDigestHeader, v)
+}
+
+// digestValue formats the SHA-256 sum as the structured field dictionary member
+func digestValue(sum []byte) string {
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum) + ":"
+}
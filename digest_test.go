@@ -0,0 +1,89 @@
+package sendremotefile
+
+import (
+	"crypto/md5" //nolint:gosec
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigest(t *testing.T) {
+	body := []byte("hello world")
+	sum := sha256.Sum256(body)
+	md5Sum := md5.Sum(body) //nolint:gosec
+	value := digestValue(sum[:])
+
+	tests := []struct {
+		name     string
+		checksum *checksum
+		encoded  bool
+		outcome  string
+		// header is the digest sent before the body, trailer the one sent after it
+		header  string
+		trailer string
+	}{
+		{name: "sha-256 checksum", checksum: &checksum{algo: checksumSHA256, expected: sum[:]}, outcome: outcomeServed, header: value},
+		{name: "no checksum", outcome: outcomeServed, trailer: value},
+		{name: "md5 checksum", checksum: &checksum{algo: checksumMD5, expected: md5Sum[:]}, outcome: outcomeServed, trailer: value},
+		{name: "encoded body", checksum: &checksum{algo: checksumSHA256, expected: sum[:]}, encoded: true, outcome: outcomeServed, trailer: value},
+		{name: "not served", outcome: outcomeTruncated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			tr := &transfer{checksum: tt.checksum}
+
+			setDigest(h, tr, tt.encoded)
+			assert.Equal(t, tt.header, h.Get(reprDigestHeader))
+			assert.Equal(t, tt.header, h.Get(contentDigestHeader))
+
+			if tt.header != "" {
+				assert.Nil(t, tr.digest)
+				assert.Empty(t, h.Values(trailerHeader))
+				return
+			}
+
+			assert.Equal(t, []string{reprDigestHeader, contentDigestHeader}, h.Values(trailerHeader))
+			require.NotNil(t, tr.digest)
+
+			tr.digest.Write(body)
+			tr.outcome = tt.outcome
+			setDigestTrailer(h, tr)
+
+			assert.Equal(t, tt.trailer, h.Get(reprDigestHeader))
+			assert.Equal(t, tt.trailer, h.Get(contentDigestHeader))
+		})
+	}
+}
+
+func TestDigestTrailer(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("hello world"))
+	}))
+	defer up.Close()
+
+	p := newTestPlugin(t, &Config{Digest: true})
+	front := httptest.NewServer(p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set(xSendRemoteHeader, up.URL)
+	})))
+	defer front.Close()
+
+	resp, err := http.Get(front.URL) //nolint:noctx
+	require.NoError(t, err)
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	sum := sha256.Sum256([]byte("hello world"))
+	assert.Equal(t, digestValue(sum[:]), resp.Trailer.Get(contentDigestHeader))
+	assert.Equal(t, digestValue(sum[:]), resp.Trailer.Get(reprDigestHeader))
+}
@@ -0,0 +1,104 @@
+package sendremotefile
+
+import (
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	xSendRemoteContentTypeHeader string = "X-Sendremotefile-Content-Type"
+	xSendRemoteFilenameHeader    string = "X-Sendremotefile-Filename"
+	xSendRemoteDispositionHeader string = "X-Sendremotefile-Disposition"
+)
+
+// directives are the per-response transfer options set by the worker
+type directives struct {
+	// urls are the upstream URL followed by the mirrors
+	urls []string
+	// rate is the requested per-transfer bandwidth cap, bytes/sec
+	rate int64
+	// contentTypeMode overrides the configured content type mode
+	contentTypeMode string
+	// filename and disposition build the Content-Disposition header
+	filename    string
+	disposition string
+	// contentType is the worker chosen content type for the forced mode
+	contentType string
+	// cacheControl is sent to the client
+	cacheControl string
+	// headers are sent to the upstream
+	headers http.Header
+	// clientHeaders are the client request headers forwarded to the upstream
+	clientHeaders http.Header
+	// timeout overrides the upstream timeout
+	timeout time.Duration
+	// checksum is the expected digest of the upstream body
+	checksum *checksum
+}
+
+// parseDirectives collects the directives from the X-Sendremotefile-* headers and removes the headers
+// from the worker response. The invalid optional header values are logged and ignored, the invalid
+// X-Sendremotefile-Options document is an error.
+func (p *Plugin) parseDirectives(h http.Header) (*directives, error) {
+	d := &directives{
+		urls:    upstreamURLs(h),
+		headers: make(http.Header),
+		timeout: timeout,
+	}
+
+	if v := h.Get(xSendRemoteRateHeader); v != "" {
+		rate, err := parseSize(v)
+		if err != nil {
+			p.log.Warn("invalid rate header value, ignoring", zap.String("value", v), zap.Error(err))
+		}
+		d.rate = rate
+	}
+
+	if v := h.Get(xSendRemoteContentTypeHeader); v != "" {
+		if validContentTypeMode(v) {
+			d.contentTypeMode = v
+		} else {
+			p.log.Warn("invalid content type mode header value, ignoring", zap.String("value", v))
+		}
+	}
+
+	if v := h.Get(xSendRemoteDispositionHeader); v != "" {
+		if v == dispositionInline || v == dispositionAttachment {
+			d.disposition = v
+		} else {
+			p.log.Warn("invalid disposition header value, ignoring", zap.String("value", v))
+		}
+	}
+
+	d.filename = h.Get(xSendRemoteFilenameHeader)
+
+	if v := h.Get(xSendRemoteChecksumHeader); v != "" {
+		c, err := parseChecksum(v)
+		if err != nil {
+			p.log.Warn("invalid checksum header value, ignoring", zap.String("value", v), zap.Error(err))
+		}
+		d.checksum = c
+	}
+
+	p.workerUpstreamHeaders(h, d.headers)
+
+	ov := h.Get(xSendRemoteOptionsHeader)
+
+	for _, hdr := range []string{xSendRemoteRateHeader, xSendRemoteContentTypeHeader, xSendRemoteDispositionHeader, xSendRemoteFilenameHeader, xSendRemoteChecksumHeader, xSendRemoteOptionsHeader} {
+		h.Del(hdr)
+	}
+
+	if ov != "" {
+		opts, err := decodeOptions(ov, p.allowedOptions)
+		if err != nil {
+			return d, err
+		}
+
+		opts.apply(d)
+		p.optionHeaders(opts.Headers, d.headers)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,199 @@
+package sendremotefile
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var errCircuitOpen = errors.New("upstream circuit is open")
+
+// fetchError describes why none of the upstream URLs could serve the file
+type fetchError struct {
+	// outcome is the transfer outcome, see metrics.go
+	outcome string
+	// status is the last upstream response status code, 0 if there was no response
+	status int
+	// retryAfter is sent to the client for the overloaded or open circuit upstreams
+	retryAfter time.Duration
+	err        error
+}
+
+// upstreamURLs collects the upstream URLs in the order of preference: X-Sendremotefile values,
+// then the X-Sendremotefile-Fallback ones, and removes the headers. Every header value is a single URL,
+// the values are not split on commas, which are valid in the URLs.
+func upstreamURLs(h http.Header) []string {
+	urls := make([]string, 0, 2)
+
+	for _, hdr := range []string{xSendRemoteHeader, xSendRemoteFallbackHeader} {
+		for _, v := range h.Values(hdr) {
+			if v = strings.TrimSpace(v); v != "" {
+				urls = append(urls, v)
+			}
+		}
+
+		h.Del(hdr)
+	}
+
+	return urls
+}
+
+// validMirrors returns the URLs without the invalid mirrors, the first (primary) URL is kept as is
+func (p *Plugin) validMirrors(urls []string) []string {
+	valid := urls[:1:1]
+	for _, u := range urls[1:] {
+		if err := checkURL(u, p.client.hosts); err != nil {
+			p.log.Warn("invalid mirror URL, skipping", p.redact.URL("url", u), p.redact.Error(err))
+			continue
+		}
+
+		valid = append(valid, u)
+	}
+
+	return valid
+}
+
+// fetch requests the upstream URLs one by one until one of them responds with 200 OK.
+// The next URL is tried on the connection errors, timeouts and retryable status codes.
+// On success, the returned function releases the concurrency slot and must be called after the body is streamed.
+func (p *Plugin) fetch(ctx context.Context, t *transfer, d *directives, urls []string) (*http.Response, func(), *fetchError) {
+	var last *fetchError
+	// the queue timeout is for the whole transfer, not per URL
+	queueDeadline := time.Now().Add(p.cfg.Limits.QueueTimeout)
+
+	for i, u := range urls {
+		host := upstreamHost(u)
+
+		release, err := p.limiter.acquireUntil(ctx, host, queueDeadline)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, &fetchError{outcome: outcomeClientAbort, err: err}
+			}
+
+			p.log.Warn("too many upstream fetches, request rejected", p.redact.URL("url", u), zap.Error(err))
+			last = &fetchError{outcome: outcomeOverloaded, retryAfter: p.cfg.Limits.RetryAfter, err: err}
+			if errors.Is(err, errGlobalLimit) {
+				// the mirrors share the global limit
+				return nil, nil, last
+			}
+
+			continue
+		}
+
+		if ok, wait := p.breakers.allow(host); !ok {
+			release()
+			p.log.Warn("upstream circuit is open, request rejected", p.redact.URL("url", u))
+			last = &fetchError{outcome: outcomeCircuitOpen, retryAfter: wait, err: errCircuitOpen}
+			continue
+		}
+
+		// hedge to the next mirror, if any
+		hedgeURL := u
+		if i+1 < len(urls) {
+			hedgeURL = urls[i+1]
+		}
+
+		a := p.request(ctx, t, d, u, hedgeURL)
+		resp, used, err := a.resp, a.url, a.err
+		t.url = used
+		// only the returned attempt counts, the hedging loser was discarded
+		t.connect += a.connect
+		if ctx.Err() != nil {
+			// the client has gone
+			release()
+			_ = a.Close()
+
+			return nil, nil, &fetchError{outcome: outcomeClientAbort, err: ctx.Err()}
+		}
+
+		if a.hedged {
+			// the hedged request holds its own slot, freed when its body is closed
+			release()
+			release = func() {}
+		}
+
+		if err != nil {
+			release()
+			p.log.Error("failed to request from the upstream", p.redact.URL("url", used), zap.Int("attempt", i+1), p.redact.Error(err))
+
+			last = &fetchError{outcome: outcomeUpstreamError, err: err}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				last.outcome = outcomeTimeout
+			}
+
+			continue
+		}
+
+		t.ttfb = a.ttfb
+		t.upstreamStatus = resp.StatusCode
+		t.expected = resp.ContentLength
+
+		if resp.StatusCode == http.StatusOK {
+			// the same object on the mirrors, no failover
+			if fe := p.checkObject(t, resp); fe != nil {
+				_ = resp.Body.Close()
+				release()
+				return nil, nil, fe
+			}
+
+			if used != urls[0] {
+				p.log.Info("serving from the fallback upstream", p.redact.URL("url", used), zap.Int("attempt", i+1))
+			} else {
+				p.log.Debug("serving from the upstream", p.redact.URL("url", used))
+			}
+
+			return resp, release, nil
+		}
+
+		_ = resp.Body.Close()
+		release()
+		p.log.Error("invalid upstream response status code", p.redact.URL("url", used), zap.Int("attempt", i+1), zap.Int("rr_response_code", t.workerStatus), zap.Int("remotefile_response_code", resp.StatusCode))
+
+		last = &fetchError{outcome: outcomeUpstreamError, status: resp.StatusCode}
+		if !slices.Contains(p.cfg.Failover.RetryableStatuses, resp.StatusCode) {
+			return nil, nil, last
+		}
+	}
+
+	return nil, nil, last
+}
+
+// fetchFailed sends the error response for the failed fetch
+func (p *Plugin) fetchFailed(w http.ResponseWriter, r *http.Request, t *transfer, fe *fetchError) {
+	t.outcome = fe.outcome
+
+	var code int
+	var errCode, detail string
+	switch fe.outcome {
+	case outcomeClientAbort:
+		// nobody to respond to
+		return
+	case outcomeOverloaded:
+		code, errCode, detail = http.StatusServiceUnavailable, errCodeOverloaded, detailUnavailable
+		w.Header().Set(retryAfterHeader, retryAfter(fe.retryAfter))
+	case outcomeCircuitOpen:
+		code, errCode, detail = http.StatusServiceUnavailable, errCodeUnavailable, detailUnavailable
+		w.Header().Set(retryAfterHeader, retryAfter(fe.retryAfter))
+	case outcomeTimeout:
+		code, errCode, detail = http.StatusGatewayTimeout, errCodeTimeout, detailUpstreamTimeout
+	case outcomeTooLarge:
+		code, errCode, detail = http.StatusBadGateway, errCodeTooLarge, detailTooLarge
+	case outcomeContentTypeRejected:
+		code, errCode, detail = http.StatusBadGateway, errCodeContentType, detailContentType
+	default:
+		code, errCode, detail = http.StatusBadGateway, errCodeUnreachable, detailUpstreamFailed
+		if fe.status > 0 {
+			code, errCode = p.cfg.downstreamStatus(fe.status), upstreamErrCode(fe.status)
+		}
+	}
+
+	p.serverTiming(w, t, false)
+	p.writeError(w, r, t, code, errCode, detail)
+}
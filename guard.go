@@ -0,0 +1,68 @@
+package sendremotefile
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// checkObject rejects the upstream object which is larger than the limit or has a not allowed content type
+func (p *Plugin) checkObject(t *transfer, resp *http.Response) *fetchError {
+	if p.maxObjectSize > 0 && resp.ContentLength > p.maxObjectSize {
+		p.log.Error("upstream object is too large", p.redact.URL("url", t.url), zap.Int64("content_length", resp.ContentLength), zap.Int64("max_object_size", p.maxObjectSize))
+		return &fetchError{outcome: outcomeTooLarge, status: resp.StatusCode}
+	}
+
+	if len(p.cfg.AllowedContentTypes) > 0 {
+		ct := resp.Header.Get(responseContentTypeKey)
+		if !allowedContentType(ct, p.cfg.AllowedContentTypes) {
+			p.log.Error("upstream content type is not allowed", p.redact.URL("url", t.url), zap.String("content_type", ct))
+			return &fetchError{outcome: outcomeContentTypeRejected, status: resp.StatusCode}
+		}
+	}
+
+	return nil
+}
+
+// checkStreamedSize aborts the transfer which passes the size limit, the Content-Length might be unknown or wrong
+func (p *Plugin) checkStreamedSize(t *transfer, n int) {
+	if p.maxObjectSize <= 0 || t.written+int64(n) <= p.maxObjectSize {
+		return
+	}
+
+	p.log.Error("upstream object exceeded the maximum size, aborting", p.redact.URL("url", t.url), zap.Int64("written", t.written), zap.Int64("max_object_size", p.maxObjectSize))
+	t.outcome = outcomeTooLarge
+	abort()
+}
+
+// allowedContentType matches the media type against the allowlist, `image/*` and `*/*` are supported.
+// The missing or invalid content type is not allowed.
+func allowedContentType(ct string, allowed []string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+
+	typ, _, _ := strings.Cut(mt, "/")
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if a == mt || a == "*/*" || a == typ+"/*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validAllowedContentType checks the allowlist entry is a `type/subtype`, `type/*` or `*/*`
+func validAllowedContentType(a string) error {
+	typ, sub, ok := strings.Cut(a, "/")
+	if !ok || typ == "" || sub == "" || (typ == "*" && sub != "*") {
+		return fmt.Errorf("invalid allowed_content_types entry %q, expected type/subtype, type/* or */*", a)
+	}
+
+	return nil
+}
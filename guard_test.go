@@ -0,0 +1,128 @@
+package sendremotefile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckObject(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxSize     string
+		allowed     []string
+		contentType string
+		status      int
+		code        string
+	}{
+		{name: "within the limit", maxSize: "1KB", contentType: "text/plain", status: http.StatusOK},
+		{name: "content length over the limit", maxSize: "10B", contentType: "text/plain", status: http.StatusBadGateway, code: errCodeTooLarge},
+		{name: "allowed type", allowed: []string{"image/*"}, contentType: "image/png", status: http.StatusOK},
+		{name: "not allowed type", allowed: []string{"image/*"}, contentType: "text/html", status: http.StatusBadGateway, code: errCodeContentType},
+		{name: "missing type", allowed: []string{"*/*"}, status: http.StatusBadGateway, code: errCodeContentType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				// no sniffing of the missing type by net/http
+				w.Header()["Content-Type"] = nil
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				_, _ = w.Write(bytes.Repeat([]byte{'a'}, 100))
+			}))
+			defer up.Close()
+
+			p := newTestPlugin(t, &Config{
+				MaxObjectSize:       tt.maxSize,
+				AllowedContentTypes: tt.allowed,
+				Errors:              &ErrorsConfig{ProblemJSON: true},
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(acceptHeader, problemContentType)
+			rec := serve(p, http.Header{xSendRemoteHeader: {up.URL}}, req)
+
+			require.Equal(t, tt.status, rec.Code)
+			if tt.code == "" {
+				return
+			}
+
+			pr := &problem{}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), pr))
+			assert.Equal(t, tt.code, pr.Code)
+		})
+	}
+}
+
+// the chunked body has no Content-Length to check before streaming
+func TestCheckStreamedSize(t *testing.T) {
+	p := newTestPlugin(t, &Config{MaxObjectSize: "10B"})
+
+	tr := newTransfer("http://upstream/f", http.StatusOK)
+	rec := httptest.NewRecorder()
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		p.stream(context.Background(), rec, strings.NewReader(strings.Repeat("a", 16)), make([]byte, 4), tr)
+	})
+
+	assert.Equal(t, outcomeTooLarge, tr.outcome)
+	assert.LessOrEqual(t, tr.written, int64(10))
+}
+
+func TestAllowedContentType(t *testing.T) {
+	tests := []struct {
+		ct      string
+		allowed []string
+		ok      bool
+	}{
+		{ct: "image/png", allowed: []string{"image/png"}, ok: true},
+		{ct: "IMAGE/PNG; q=1", allowed: []string{"image/png"}, ok: true},
+		{ct: "image/webp", allowed: []string{"image/*"}, ok: true},
+		{ct: "application/pdf", allowed: []string{"Application/PDF"}, ok: true},
+		{ct: "text/plain", allowed: []string{"*/*"}, ok: true},
+		{ct: "text/plain", allowed: []string{"image/*", "application/pdf"}},
+		{ct: "", allowed: []string{"*/*"}},
+		{ct: "not a type", allowed: []string{"*/*"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ct, func(t *testing.T) {
+			assert.Equal(t, tt.ok, allowedContentType(tt.ct, tt.allowed))
+		})
+	}
+}
+
+func TestValidAllowedContentType(t *testing.T) {
+	tests := []struct {
+		entry string
+		err   bool
+	}{
+		{entry: "image/png"},
+		{entry: "image/*"},
+		{entry: "*/*"},
+		{entry: "image", err: true},
+		{entry: "/png", err: true},
+		{entry: "image/", err: true},
+		{entry: "*/png", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			err := validAllowedContentType(tt.entry)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
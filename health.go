@@ -0,0 +1,128 @@
+package sendremotefile
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/roadrunner-server/api/v4/plugins/v1/status"
+	"go.uber.org/zap"
+)
+
+// probe is the reachability state of a single upstream probe URL
+type probe struct {
+	url      string
+	failures int
+	open     bool
+}
+
+// prober checks the upstream reachability with HEAD requests, the results are cached for the configured TTL
+type prober struct {
+	mu        sync.Mutex
+	cfg       *HealthConfig
+	log       *zap.Logger
+	redact    *redactor
+	metrics   *statsExporter
+	probes    []*probe
+	checkedAt time.Time
+	client    *clientOptions
+}
+
+func newProber(cfg *HealthConfig, log *zap.Logger, redact *redactor, metrics *statsExporter, client *clientOptions) *prober {
+	probes := make([]*probe, 0, len(cfg.ProbeURLs))
+	for _, u := range cfg.ProbeURLs {
+		probes = append(probes, &probe{url: u})
+	}
+
+	return &prober{
+		cfg:     cfg,
+		log:     log,
+		redact:  redact,
+		metrics: metrics,
+		probes:  probes,
+		client:  client,
+	}
+}
+
+// ready returns false if the circuit of at least one probe is open
+func (pr *prober) ready() bool {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if time.Since(pr.checkedAt) >= pr.cfg.CacheTTL {
+		pr.check()
+		pr.checkedAt = time.Now()
+	}
+
+	for _, pb := range pr.probes {
+		if pb.open {
+			return false
+		}
+	}
+
+	return true
+}
+
+// check runs all probes concurrently, should be called under the lock
+func (pr *prober) check() {
+	wg := &sync.WaitGroup{}
+	wg.Add(len(pr.probes))
+
+	for _, pb := range pr.probes {
+		go func(pb *probe) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), pr.cfg.ProbeTimeout)
+			defer cancel()
+
+			resp, err := NewClient(pb.url, pr.cfg.ProbeTimeout, nil, pr.client).Probe(ctx)
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+
+			// any non-5xx response means that the storage is reachable, presigned URLs may reject HEAD with 403
+			if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+				pb.failures++
+				if !pb.open && pb.failures >= pr.cfg.FailureThreshold {
+					pb.open = true
+					pr.log.Warn("upstream probe failed, circuit is open", pr.redact.URL("url", pb.url), zap.Int("failures", pb.failures), pr.redact.Error(err))
+				}
+			} else {
+				if pb.open {
+					pr.log.Info("upstream probe succeeded, circuit is closed", pr.redact.URL("url", pb.url))
+				}
+				pb.failures = 0
+				pb.open = false
+			}
+
+			var v float64
+			if pb.open {
+				v = 1
+			}
+			pr.metrics.probeOpen.WithLabelValues(pr.redact.hostPath(pb.url)).Set(v)
+		}(pb)
+	}
+
+	wg.Wait()
+}
+
+// Status return status of the particular plugin
+func (p *Plugin) Status() (*status.Status, error) {
+	return &status.Status{
+		Code: http.StatusOK,
+	}, nil
+}
+
+// Ready return readiness status of the particular plugin, based on the upstream probes
+func (p *Plugin) Ready() (*status.Status, error) {
+	if !p.prober.ready() {
+		return &status.Status{
+			Code: http.StatusServiceUnavailable,
+		}, nil
+	}
+
+	return &status.Status{
+		Code: http.StatusOK,
+	}, nil
+}
@@ -0,0 +1,254 @@
+package sendremotefile
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ttfbSamples is the number of the recent upstream TTFBs kept to calculate the hedging delay
+const ttfbSamples int = 1000
+
+// hedger keeps the recent upstream TTFBs to derive the hedging delay from the configured percentile
+type hedger struct {
+	mu      sync.Mutex
+	cfg     *HedgingConfig
+	samples []time.Duration
+	next    int
+}
+
+func newHedger(cfg *HedgingConfig) *hedger {
+	return &hedger{
+		cfg:     cfg,
+		samples: make([]time.Duration, 0, ttfbSamples),
+	}
+}
+
+func (h *hedger) observe(ttfb time.Duration) {
+	if !h.cfg.Enabled {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.samples) < ttfbSamples {
+		h.samples = append(h.samples, ttfb)
+		return
+	}
+
+	h.samples[h.next] = ttfb
+	h.next = (h.next + 1) % ttfbSamples
+}
+
+// delay returns the configured percentile of the recent TTFBs, or the configured delay if there are not enough samples
+func (h *hedger) delay() time.Duration {
+	if h.cfg.Percentile <= 0 {
+		return h.cfg.Delay
+	}
+
+	h.mu.Lock()
+	if len(h.samples) < h.cfg.MinSamples {
+		h.mu.Unlock()
+		return h.cfg.Delay
+	}
+
+	sorted := slices.Clone(h.samples)
+	h.mu.Unlock()
+
+	slices.Sort(sorted)
+	idx := min(int(float64(len(sorted))*h.cfg.Percentile), len(sorted)-1)
+
+	return sorted[idx]
+}
+
+// cancelBody cancels the request context of the hedged request when its body is closed,
+// and frees the limiter slot of the hedge host, if any
+type cancelBody struct {
+	io.ReadCloser
+	cancel  context.CancelFunc
+	release func()
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	if b.release != nil {
+		b.release()
+	}
+	return err
+}
+
+// attempt is the result of a single upstream request
+type attempt struct {
+	url  string
+	resp *http.Response
+	err  error
+	// ttfb is the time until the response headers of this request only
+	ttfb time.Duration
+	// connect is the time spent to get the connections of this request, measured with the server timing only
+	connect time.Duration
+	// hedged is set for the hedged request, it holds its own limiter slot
+	hedged bool
+}
+
+func (a *attempt) ok() bool {
+	return a.err == nil && a.resp.StatusCode == http.StatusOK
+}
+
+// Close discards the attempt response
+func (a *attempt) Close() error {
+	if a.err != nil {
+		return nil
+	}
+
+	return a.resp.Body.Close()
+}
+
+// send sends a single upstream request and reports its result to the host circuit breaker
+func (p *Plugin) send(ctx context.Context, d *directives, url string) *attempt {
+	a := &attempt{url: url}
+	rctx := ctx
+	if p.cfg.ServerTiming {
+		rctx = withConnectTrace(ctx, a)
+	}
+
+	start := time.Now()
+	resp, err := NewClient(url, d.timeout, p.upstreamHeaders(url, d), p.client).Request(rctx)
+	a.resp, a.err, a.ttfb = resp, err, time.Since(start)
+
+	if err == nil {
+		p.hedge.observe(a.ttfb)
+	}
+
+	host := upstreamHost(url)
+	if ctx.Err() != nil {
+		// canceled by the client or by the hedging, it says nothing about the upstream
+		p.breakers.release(host)
+	} else {
+		p.breakers.report(host, err == nil && resp.StatusCode < http.StatusInternalServerError)
+	}
+
+	return a
+}
+
+// acquireHedge takes a free limiter slot and the circuit breaker permission for the hedge host. The hedged request
+// is opportunistic, it does not wait in the limiter queue.
+func (p *Plugin) acquireHedge(url string) (func(), bool) {
+	host := upstreamHost(url)
+
+	release, ok := p.limiter.tryAcquire(host)
+	if !ok {
+		p.log.Debug("no free upstream fetch slot, not hedging", p.redact.URL("url", url))
+		return nil, false
+	}
+
+	if ok, _ := p.breakers.allow(host); !ok {
+		release()
+		p.log.Debug("upstream circuit is open, not hedging", p.redact.URL("url", url))
+		return nil, false
+	}
+
+	return release, true
+}
+
+type hedgeResult struct {
+	idx int
+	a   *attempt
+}
+
+// request sends the upstream request. With the hedging enabled, a second request to the hedge URL
+// (a mirror or the same URL) is sent if the first one has no response headers after the hedging delay.
+// The first 200 response wins and the other request is canceled, otherwise the first failure is returned.
+// The primary request must be allowed by the limiter and the circuit breaker by the caller.
+func (p *Plugin) request(ctx context.Context, t *transfer, d *directives, url string, hedgeURL string) *attempt {
+	t.attempts++
+
+	if !p.cfg.Hedging.Enabled {
+		return p.send(ctx, d, url)
+	}
+
+	urls := []string{url, hedgeURL}
+	cancels := make([]context.CancelFunc, 2)
+	releases := make([]func(), 2)
+	results := make(chan hedgeResult, 2)
+
+	start := func(idx int) {
+		rctx, cancel := context.WithCancel(ctx)
+		cancels[idx] = cancel
+
+		go func() {
+			results <- hedgeResult{idx: idx, a: p.send(rctx, d, urls[idx])}
+		}()
+	}
+
+	// wrap sets the body cleanup of the attempt returned to the caller
+	wrap := func(r hedgeResult) *attempt {
+		if r.a.err != nil {
+			cancels[r.idx]()
+			if releases[r.idx] != nil {
+				releases[r.idx]()
+			}
+			return r.a
+		}
+
+		r.a.resp.Body = &cancelBody{ReadCloser: r.a.resp.Body, cancel: cancels[r.idx], release: releases[r.idx]}
+		return r.a
+	}
+
+	start(0)
+
+	timer := time.NewTimer(p.hedge.delay())
+	defer timer.Stop()
+
+	var failed []hedgeResult
+	for pending := 1; pending > 0; {
+		select {
+		case <-timer.C:
+			release, ok := p.acquireHedge(hedgeURL)
+			if !ok {
+				continue
+			}
+
+			p.log.Debug("upstream is slow, sending the hedged request", p.redact.URL("url", hedgeURL))
+			t.attempts++
+			releases[1] = release
+			start(1)
+			pending++
+		case res := <-results:
+			pending--
+			res.a.hedged = res.idx == 1
+
+			if !res.a.ok() {
+				// the other request might still succeed
+				failed = append(failed, res)
+				continue
+			}
+
+			// the first 200 response wins, cancel the other request and discard its response if it comes
+			if pending > 0 {
+				other := 1 - res.idx
+				cancels[other]()
+
+				go func() {
+					_ = wrap(<-results).Close()
+				}()
+			}
+
+			for _, f := range failed {
+				_ = wrap(f).Close()
+			}
+
+			return wrap(res)
+		}
+	}
+
+	for _, f := range failed[1:] {
+		_ = wrap(f).Close()
+	}
+
+	return wrap(failed[0])
+}
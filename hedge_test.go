@@ -0,0 +1,179 @@
+package sendremotefile
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// upstream responds with the status and the body after the delay, or when the client goes away
+func upstream(t *testing.T, delay time.Duration, status int, body string) string {
+	t.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts.URL
+}
+
+func TestRequestHedging(t *testing.T) {
+	tests := []struct {
+		name     string
+		primary  func(t *testing.T) string
+		hedge    func(t *testing.T) string
+		enabled  bool
+		status   int
+		body     string
+		hedged   bool
+		attempts int
+	}{
+		{
+			name:     "disabled",
+			primary:  func(t *testing.T) string { return upstream(t, 100*time.Millisecond, http.StatusOK, "primary") },
+			hedge:    func(t *testing.T) string { return upstream(t, 0, http.StatusOK, "hedge") },
+			status:   http.StatusOK,
+			body:     "primary",
+			attempts: 1,
+		},
+		{
+			name:     "fast primary",
+			primary:  func(t *testing.T) string { return upstream(t, 0, http.StatusOK, "primary") },
+			hedge:    func(t *testing.T) string { return upstream(t, 0, http.StatusOK, "hedge") },
+			enabled:  true,
+			status:   http.StatusOK,
+			body:     "primary",
+			attempts: 1,
+		},
+		{
+			name:     "slow primary",
+			primary:  func(t *testing.T) string { return upstream(t, time.Second, http.StatusOK, "primary") },
+			hedge:    func(t *testing.T) string { return upstream(t, 0, http.StatusOK, "hedge") },
+			enabled:  true,
+			status:   http.StatusOK,
+			body:     "hedge",
+			hedged:   true,
+			attempts: 2,
+		},
+		{
+			name:     "failed hedge does not win",
+			primary:  func(t *testing.T) string { return upstream(t, 200*time.Millisecond, http.StatusOK, "primary") },
+			hedge:    func(t *testing.T) string { return upstream(t, 0, http.StatusInternalServerError, "hedge") },
+			enabled:  true,
+			status:   http.StatusOK,
+			body:     "primary",
+			attempts: 2,
+		},
+		{
+			name:     "both failed",
+			primary:  func(t *testing.T) string { return upstream(t, 100*time.Millisecond, http.StatusNotFound, "primary") },
+			hedge:    func(t *testing.T) string { return upstream(t, 0, http.StatusServiceUnavailable, "hedge") },
+			enabled:  true,
+			status:   http.StatusServiceUnavailable,
+			body:     "hedge",
+			hedged:   true,
+			attempts: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPlugin(t, &Config{Hedging: &HedgingConfig{Enabled: tt.enabled, Delay: 20 * time.Millisecond}})
+
+			tr := &transfer{}
+			d := &directives{timeout: 5 * time.Second}
+			a := p.request(context.Background(), tr, d, tt.primary(t), tt.hedge(t))
+			require.NoError(t, a.err)
+			defer func() {
+				_ = a.Close()
+			}()
+
+			body, err := io.ReadAll(a.resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.status, a.resp.StatusCode)
+			assert.Equal(t, tt.body, string(body))
+			assert.Equal(t, tt.hedged, a.hedged)
+			assert.Equal(t, tt.attempts, tr.attempts)
+		})
+	}
+}
+
+func TestHedgeNoFreeSlot(t *testing.T) {
+	p := newTestPlugin(t, &Config{
+		Hedging: &HedgingConfig{Enabled: true, Delay: 10 * time.Millisecond},
+		Limits:  &LimitsConfig{MaxInFlightPerHost: 1},
+	})
+
+	// the primary and the hedge URL share the host, the primary holds its only slot
+	url := upstream(t, 100*time.Millisecond, http.StatusOK, "primary")
+	release, err := p.limiter.acquire(context.Background(), upstreamHost(url))
+	require.NoError(t, err)
+	defer release()
+
+	tr := &transfer{}
+	a := p.request(context.Background(), tr, &directives{timeout: 5 * time.Second}, url, url)
+	require.NoError(t, a.err)
+	defer func() {
+		_ = a.Close()
+	}()
+
+	assert.False(t, a.hedged)
+	assert.Equal(t, 1, tr.attempts)
+}
+
+func TestHedgerDelay(t *testing.T) {
+	tests := []struct {
+		name       string
+		percentile float64
+		samples    int
+		delay      time.Duration
+	}{
+		{name: "fixed", samples: 100, delay: time.Second},
+		{name: "not enough samples", percentile: 0.9, samples: 9, delay: time.Second},
+		{name: "p90", percentile: 0.9, samples: 100, delay: 90 * time.Millisecond},
+		{name: "p50", percentile: 0.5, samples: 10, delay: 5 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHedger(&HedgingConfig{Enabled: true, Delay: time.Second, Percentile: tt.percentile, MinSamples: 10})
+			for i := tt.samples - 1; i >= 0; i-- {
+				h.observe(time.Duration(i) * time.Millisecond)
+			}
+
+			assert.Equal(t, tt.delay, h.delay())
+		})
+	}
+}
+
+func TestHedgingServerTiming(t *testing.T) {
+	p := newTestPlugin(t, &Config{
+		ServerTiming: true,
+		Hedging:      &HedgingConfig{Enabled: true, Delay: time.Millisecond},
+	})
+
+	// both requests connect concurrently, each one is timed on its own
+	url := upstream(t, 5*time.Millisecond, http.StatusOK, "body")
+	for range 20 {
+		rec := serve(p, http.Header{xSendRemoteHeader: {url}}, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "body", rec.Body.String())
+		assert.Contains(t, rec.Result().Header.Get(serverTimingHeader), "upstream-connect;dur=")
+	}
+}
@@ -0,0 +1,85 @@
+package sendremotefile
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// hostPolicy restricts the upstream hosts and addresses, for the URLs and every redirect hop
+type hostPolicy struct {
+	allowed     []string
+	denyPrivate bool
+	networks    []*net.IPNet
+}
+
+func newHostPolicy(cfg *HostsConfig) *hostPolicy {
+	hp := &hostPolicy{
+		denyPrivate: cfg.DenyPrivate,
+		networks:    make([]*net.IPNet, 0, len(cfg.AllowedNetworks)),
+	}
+
+	for _, h := range cfg.Allowed {
+		hp.allowed = append(hp.allowed, strings.ToLower(h))
+	}
+
+	for _, n := range cfg.AllowedNetworks {
+		// already validated
+		_, ipNet, _ := net.ParseCIDR(n)
+		hp.networks = append(hp.networks, ipNet)
+	}
+
+	return hp
+}
+
+// checkHost checks the URL host (without the port) against the allowlist, `*.example.com` matches the subdomains
+func (hp *hostPolicy) checkHost(host string) error {
+	if hp == nil || len(hp.allowed) == 0 {
+		return nil
+	}
+
+	host = strings.ToLower(host)
+	for _, a := range hp.allowed {
+		if a == host || (strings.HasPrefix(a, "*.") && strings.HasSuffix(host, a[1:])) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("upstream host %q is not allowed", host)
+}
+
+// checkIP rejects the non-public addresses, unless they are in the allowed networks
+func (hp *hostPolicy) checkIP(ip net.IP) error {
+	if hp == nil || !hp.denyPrivate {
+		return nil
+	}
+
+	for _, n := range hp.networks {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("upstream address %s is not allowed", ip)
+	}
+
+	return nil
+}
+
+// control checks the resolved address right before connecting, so the DNS answers can't bypass the policy
+func (hp *hostPolicy) control(_ string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("upstream address %q is not an IP", host)
+	}
+
+	return hp.checkIP(ip)
+}
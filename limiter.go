@@ -0,0 +1,202 @@
+package sendremotefile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errQueueFull    = errors.New("upstream fetch wait queue is full")
+	errQueueTimeout = errors.New("timed out waiting for an upstream fetch slot")
+	// errGlobalLimit wraps the errors of the global slot, the other upstream hosts can't help
+	errGlobalLimit = errors.New("global upstream fetch limit")
+)
+
+// hostSlots are the slots of a single upstream host
+type hostSlots struct {
+	sem chan struct{}
+	// refs is the number of the acquirers holding or waiting for a slot, the entry is evicted only when unreferenced
+	refs     int
+	lastUsed time.Time
+}
+
+// limiter caps the number of the in-flight upstream fetches, globally and per upstream host.
+// The hosts come from the worker URLs, so the idle unreferenced hosts are evicted.
+type limiter struct {
+	cfg     *LimitsConfig
+	global  chan struct{}
+	mu      sync.Mutex
+	hosts   map[string]*hostSlots
+	waiting atomic.Int64
+	idleTTL time.Duration
+	sweptAt time.Time
+}
+
+func newLimiter(cfg *LimitsConfig) *limiter {
+	l := &limiter{
+		cfg:     cfg,
+		hosts:   make(map[string]*hostSlots),
+		idleTTL: idleHostTTL,
+		sweptAt: time.Now(),
+	}
+
+	if cfg.MaxInFlight > 0 {
+		l.global = make(chan struct{}, cfg.MaxInFlight)
+	}
+
+	return l
+}
+
+// acquire takes the host and the global slots, waiting in the bounded queue up to the queue timeout
+// if there are no free slots. The returned function must be called to free the slots.
+func (l *limiter) acquire(ctx context.Context, host string) (func(), error) {
+	return l.acquireUntil(ctx, host, time.Now().Add(l.cfg.QueueTimeout))
+}
+
+// acquireUntil is the acquire waiting in the queue up to the deadline, shared by the upstream URLs of a transfer
+func (l *limiter) acquireUntil(ctx context.Context, host string, deadline time.Time) (func(), error) {
+	sems, unref := l.sems(host)
+
+	acquired := make([]chan struct{}, 0, len(sems))
+	release := func() {
+		for _, sem := range acquired {
+			<-sem
+		}
+		unref()
+	}
+
+	var timer *time.Timer
+	for _, sem := range sems {
+		select {
+		case sem <- struct{}{}:
+			acquired = append(acquired, sem)
+			continue
+		default:
+		}
+
+		// no free slot, join the queue
+		if timer == nil {
+			if l.waiting.Add(1) > int64(l.cfg.MaxQueue) {
+				l.waiting.Add(-1)
+				release()
+				return nil, l.slotError(sem, errQueueFull)
+			}
+			defer l.waiting.Add(-1)
+
+			timer = time.NewTimer(time.Until(deadline))
+			defer timer.Stop()
+		}
+
+		select {
+		case sem <- struct{}{}:
+			acquired = append(acquired, sem)
+		case <-timer.C:
+			release()
+			return nil, l.slotError(sem, errQueueTimeout)
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+
+	return release, nil
+}
+
+// tryAcquire takes the host and the global slots only if they are free, without waiting in the queue
+func (l *limiter) tryAcquire(host string) (func(), bool) {
+	sems, unref := l.sems(host)
+
+	acquired := make([]chan struct{}, 0, len(sems))
+	release := func() {
+		for _, sem := range acquired {
+			<-sem
+		}
+		unref()
+	}
+
+	for _, sem := range sems {
+		select {
+		case sem <- struct{}{}:
+			acquired = append(acquired, sem)
+		default:
+			release()
+			return nil, false
+		}
+	}
+
+	return release, true
+}
+
+// slotError marks the error of the global slot with the errGlobalLimit
+func (l *limiter) slotError(sem chan struct{}, err error) error {
+	if sem == l.global {
+		return fmt.Errorf("%w: %w", errGlobalLimit, err)
+	}
+
+	return err
+}
+
+// sems returns the semaphores to take for the host, the returned function drops the host reference.
+// The host slot goes first, so the fetches waiting for a busy host do not hold the global slots.
+func (l *limiter) sems(host string) ([]chan struct{}, func()) {
+	sems := make([]chan struct{}, 0, 2)
+	unref := func() {}
+
+	if l.cfg.MaxInFlightPerHost > 0 {
+		hs := l.ref(host)
+		sems = append(sems, hs.sem)
+		unref = func() {
+			l.unref(hs)
+		}
+	}
+
+	if l.global != nil {
+		sems = append(sems, l.global)
+	}
+
+	return sems, unref
+}
+
+func (l *limiter) ref(host string) *hostSlots {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.sweptAt) >= l.idleTTL {
+		l.sweep(now)
+	}
+
+	hs, ok := l.hosts[host]
+	if !ok {
+		hs = &hostSlots{sem: make(chan struct{}, l.cfg.MaxInFlightPerHost)}
+		l.hosts[host] = hs
+	}
+
+	hs.refs++
+	hs.lastUsed = now
+
+	return hs
+}
+
+func (l *limiter) unref(hs *hostSlots) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	hs.refs--
+	hs.lastUsed = time.Now()
+}
+
+// sweep evicts the unreferenced hosts idle for the idleTTL
+func (l *limiter) sweep(now time.Time) {
+	l.sweptAt = now
+
+	for host, hs := range l.hosts {
+		if hs.refs == 0 && now.Sub(hs.lastUsed) >= l.idleTTL {
+			delete(l.hosts, host)
+		}
+	}
+}
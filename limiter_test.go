@@ -0,0 +1,220 @@
+package sendremotefile
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterAcquire(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *LimitsConfig
+		// held are the hosts of the slots taken before the tested acquire
+		held []string
+		host string
+		err  error
+		// global is set for the errors of the global slot
+		global bool
+	}{
+		{name: "unlimited", cfg: &LimitsConfig{}, held: []string{"a", "a", "a"}, host: "a"},
+		{name: "free global slot", cfg: &LimitsConfig{MaxInFlight: 2}, held: []string{"a"}, host: "b"},
+		{name: "no queue", cfg: &LimitsConfig{MaxInFlight: 1}, held: []string{"a"}, host: "b", err: errQueueFull, global: true},
+		{name: "queue timeout", cfg: &LimitsConfig{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: 20 * time.Millisecond}, held: []string{"a"}, host: "b", err: errQueueTimeout, global: true},
+		{name: "free host slot", cfg: &LimitsConfig{MaxInFlightPerHost: 1}, held: []string{"a"}, host: "b"},
+		{name: "busy host", cfg: &LimitsConfig{MaxInFlightPerHost: 1}, held: []string{"a"}, host: "a", err: errQueueFull},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLimiter(tt.cfg)
+			for _, h := range tt.held {
+				_, err := l.acquire(context.Background(), h)
+				require.NoError(t, err)
+			}
+
+			release, err := l.acquire(context.Background(), tt.host)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				assert.Equal(t, tt.global, errors.Is(err, errGlobalLimit))
+				assert.Zero(t, l.waiting.Load())
+				return
+			}
+
+			require.NoError(t, err)
+			release()
+		})
+	}
+}
+
+func TestLimiterQueue(t *testing.T) {
+	l := newLimiter(&LimitsConfig{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: time.Second})
+
+	release, err := l.acquire(context.Background(), "a")
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		r, err := l.acquire(context.Background(), "b")
+		if err == nil {
+			r()
+		}
+		done <- err
+	}()
+
+	// the queued fetch gets the freed slot
+	time.Sleep(20 * time.Millisecond)
+	release()
+	assert.NoError(t, <-done)
+
+	// the canceled client leaves the queue
+	release, err = l.acquire(context.Background(), "a")
+	require.NoError(t, err)
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = l.acquire(ctx, "b")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// the fetches waiting for a busy host must not block the other hosts
+func TestLimiterBusyHost(t *testing.T) {
+	l := newLimiter(&LimitsConfig{MaxInFlight: 3, MaxInFlightPerHost: 1, MaxQueue: 10, QueueTimeout: 300 * time.Millisecond})
+
+	release, err := l.acquire(context.Background(), "a")
+	require.NoError(t, err)
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for range 2 {
+		go func() {
+			if r, err := l.acquire(ctx, "a"); err == nil {
+				r()
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	start := time.Now()
+	releaseB, err := l.acquire(context.Background(), "b")
+	require.NoError(t, err)
+	releaseB()
+
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestLimiterTryAcquire(t *testing.T) {
+	l := newLimiter(&LimitsConfig{MaxInFlight: 2, MaxInFlightPerHost: 1, MaxQueue: 10})
+
+	release, ok := l.tryAcquire("a")
+	require.True(t, ok)
+
+	// no waiting in the queue for the busy host
+	_, ok = l.tryAcquire("a")
+	assert.False(t, ok)
+
+	releaseB, ok := l.tryAcquire("b")
+	require.True(t, ok)
+
+	_, ok = l.tryAcquire("c")
+	assert.False(t, ok)
+
+	release()
+	releaseB()
+
+	// the failed attempts took no slots
+	assert.Empty(t, l.global)
+	for _, hs := range l.hosts {
+		assert.Empty(t, hs.sem)
+		assert.Zero(t, hs.refs)
+	}
+}
+
+func TestLimiterEviction(t *testing.T) {
+	l := newLimiter(&LimitsConfig{MaxInFlightPerHost: 1, MaxQueue: 1, QueueTimeout: time.Second})
+	l.idleTTL = time.Hour
+
+	release, err := l.acquire(context.Background(), "idle")
+	require.NoError(t, err)
+	release()
+
+	held, err := l.acquire(context.Background(), "held")
+	require.NoError(t, err)
+
+	// the waiter references the busy host entry
+	done := make(chan error, 1)
+	go func() {
+		r, err := l.acquire(context.Background(), "held")
+		if err == nil {
+			r()
+		}
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	l.mu.Lock()
+	for _, hs := range l.hosts {
+		hs.lastUsed = hs.lastUsed.Add(-2 * time.Hour)
+	}
+	l.sweptAt = l.sweptAt.Add(-2 * time.Hour)
+	l.mu.Unlock()
+
+	release, err = l.acquire(context.Background(), "new")
+	require.NoError(t, err)
+	release()
+
+	l.mu.Lock()
+	assert.NotContains(t, l.hosts, "idle")
+	assert.Contains(t, l.hosts, "held")
+	assert.Contains(t, l.hosts, "new")
+	l.mu.Unlock()
+
+	held()
+	assert.NoError(t, <-done)
+}
+
+// the queue timeout is for the whole failover, the mirrors can't help with the global limit
+func TestFetchQueueTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits *LimitsConfig
+		// held are the indexes of the URLs which hosts slots are taken
+		held []int
+	}{
+		{name: "global", limits: &LimitsConfig{MaxInFlight: 1, MaxQueue: 10, QueueTimeout: 200 * time.Millisecond}, held: []int{0}},
+		{name: "all hosts", limits: &LimitsConfig{MaxInFlightPerHost: 1, MaxQueue: 10, QueueTimeout: 200 * time.Millisecond}, held: []int{0, 1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPlugin(t, &Config{Limits: tt.limits})
+
+			urls := []string{
+				upstream(t, 0, http.StatusOK, "a"),
+				upstream(t, 0, http.StatusOK, "b"),
+				upstream(t, 0, http.StatusOK, "c"),
+			}
+			for _, i := range tt.held {
+				release, err := p.limiter.acquire(context.Background(), upstreamHost(urls[i]))
+				require.NoError(t, err)
+				defer release()
+			}
+
+			start := time.Now()
+			rec := serve(p, http.Header{xSendRemoteHeader: urls[:1], xSendRemoteFallbackHeader: urls[1:]}, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+			assert.NotEmpty(t, rec.Header().Get(retryAfterHeader))
+			assert.Less(t, time.Since(start), 400*time.Millisecond)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package sendremotefile
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace string = "rr_http"
+	subsystem string = "sendremotefile"
+)
+
+// transfer outcomes, used as the `outcome` label of the requests counter
+const (
+	outcomeServed              string = "served"
+	outcomeUpstreamError       string = "upstream_error"
+	outcomeTimeout             string = "timeout"
+	outcomeInvalidHeader       string = "invalid_header"
+	outcomeTruncated           string = "truncated"
+	outcomeClientAbort         string = "client_abort"
+	outcomeCircuitOpen         string = "circuit_open"
+	outcomeOverloaded          string = "overloaded"
+	outcomeSlowClient          string = "slow_client"
+	outcomeMaxDuration         string = "max_duration"
+	outcomeChecksumMismatch    string = "checksum_mismatch"
+	outcomeTooLarge            string = "too_large"
+	outcomeContentTypeRejected string = "content_type_rejected"
+)
+
+type statsExporter struct {
+	requests       *prometheus.CounterVec
+	upstreamStatus *prometheus.CounterVec
+	bytes          prometheus.Counter
+	ttfb           prometheus.Histogram
+	duration       *prometheus.HistogramVec
+	inFlight       prometheus.Gauge
+	probeOpen      *prometheus.GaugeVec
+	circuitState   *prometheus.GaugeVec
+}
+
+func newStatsExporter() *statsExporter {
+	return &statsExporter{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_total",
+			Help:      "Total number of remote file transfers by outcome.",
+		}, []string{"outcome"}),
+		upstreamStatus: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "upstream_responses_total",
+			Help:      "Total number of upstream responses by status code.",
+		}, []string{"code"}),
+		bytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "bytes_total",
+			Help:      "Total number of bytes sent to the clients.",
+		}),
+		ttfb: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "upstream_ttfb_seconds",
+			Help:      "Time until the upstream response headers were received.",
+			Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "transfer_duration_seconds",
+			Help:      "Total duration of the remote file transfers by outcome.",
+			Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+		}, []string{"outcome"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "in_flight",
+			Help:      "Number of the remote file transfers in progress.",
+		}),
+		probeOpen: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "probe_circuit_open",
+			Help:      "Circuit state of the upstream readiness probes, 1 is open (unreachable).",
+		}, []string{"target"}),
+		circuitState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "circuit_state",
+			Help:      "Circuit breaker state of the upstream hosts: 0 closed, 1 half-open, 2 open.",
+		}, []string{"host"}),
+	}
+}
+
+func (s *statsExporter) Describe(d chan<- *prometheus.Desc) {
+	s.requests.Describe(d)
+	s.upstreamStatus.Describe(d)
+	s.bytes.Describe(d)
+	s.ttfb.Describe(d)
+	s.duration.Describe(d)
+	s.inFlight.Describe(d)
+	s.probeOpen.Describe(d)
+	s.circuitState.Describe(d)
+}
+
+func (s *statsExporter) Collect(ch chan<- prometheus.Metric) {
+	s.requests.Collect(ch)
+	s.upstreamStatus.Collect(ch)
+	s.bytes.Collect(ch)
+	s.ttfb.Collect(ch)
+	s.duration.Collect(ch)
+	s.inFlight.Collect(ch)
+	s.probeOpen.Collect(ch)
+	s.circuitState.Collect(ch)
+}
+
+// observe records the finished transfer
+func (s *statsExporter) observe(t *transfer) {
+	s.requests.WithLabelValues(t.outcome).Inc()
+	s.duration.WithLabelValues(t.outcome).Observe(t.duration().Seconds())
+
+	if t.upstreamStatus > 0 {
+		s.upstreamStatus.WithLabelValues(strconv.Itoa(t.upstreamStatus)).Inc()
+		s.ttfb.Observe(t.ttfb.Seconds())
+	}
+
+	if t.written > 0 {
+		s.bytes.Add(float64(t.written))
+	}
+}
+
+func (p *Plugin) MetricsCollector() []prometheus.Collector {
+	return []prometheus.Collector{p.metrics}
+}
@@ -0,0 +1,227 @@
+package sendremotefile
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const xSendRemoteOptionsHeader string = "X-Sendremotefile-Options"
+
+// options keys
+const (
+	optionURLs         string = "urls"
+	optionHeaders      string = "headers"
+	optionFilename     string = "filename"
+	optionContentType  string = "content_type"
+	optionDisposition  string = "disposition"
+	optionCacheControl string = "cache_control"
+	optionRate         string = "rate"
+	optionTimeout      string = "timeout"
+	optionChecksum     string = "checksum"
+)
+
+// knownOptions are all the supported options keys
+var knownOptions = []string{
+	optionURLs,
+	optionHeaders,
+	optionFilename,
+	optionContentType,
+	optionDisposition,
+	optionCacheControl,
+	optionRate,
+	optionTimeout,
+	optionChecksum,
+}
+
+// options is the X-Sendremotefile-Options document, a JSON object (or base64 encoded JSON)
+type options struct {
+	// URLs are the upstream URL followed by the mirrors, replace the X-Sendremotefile ones
+	URLs []string `json:"urls"`
+	// Headers are sent to the upstream
+	Headers  map[string]string `json:"headers"`
+	Filename *string           `json:"filename"`
+	// ContentType is a content type mode or a media type to send
+	ContentType  *string `json:"content_type"`
+	Disposition  *string `json:"disposition"`
+	CacheControl *string `json:"cache_control"`
+	// Rate is the per-transfer bandwidth cap, e.g. `2MB`
+	Rate *string `json:"rate"`
+	// Timeout is the upstream timeout, e.g. `10s`
+	Timeout *string `json:"timeout"`
+	// Checksum is the expected digest of the upstream body, e.g. `sha256=<hex>`
+	Checksum *string `json:"checksum"`
+}
+
+// decodeOptions decodes and validates the options header value, only the allowed options may be set
+func decodeOptions(value string, allowed map[string]struct{}) (*options, error) {
+	raw := []byte(strings.TrimSpace(value))
+	if len(raw) > 0 && raw[0] != '{' {
+		var err error
+		raw, err = decodeBase64(string(raw))
+		if err != nil {
+			return nil, errors.New("options must be a JSON object or a base64 encoded JSON object")
+		}
+	}
+
+	keys := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(raw, &keys); err != nil {
+		return nil, fmt.Errorf("invalid options JSON: %w", err)
+	}
+
+	for k := range keys {
+		if _, ok := allowed[k]; !ok {
+			return nil, fmt.Errorf("option %q is unknown or not allowed", k)
+		}
+	}
+
+	opts := &options{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(opts); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+
+	return opts, opts.validate()
+}
+
+func (o *options) validate() error {
+	if o.URLs != nil && len(o.URLs) == 0 {
+		return errors.New("urls must not be empty")
+	}
+
+	for k, v := range o.Headers {
+		if !validHeader(k, v) {
+			return fmt.Errorf("invalid upstream header %q", k)
+		}
+	}
+
+	if o.Disposition != nil && *o.Disposition != dispositionInline && *o.Disposition != dispositionAttachment {
+		return fmt.Errorf("invalid disposition %q, expected inline or attachment", *o.Disposition)
+	}
+
+	if o.ContentType != nil && !validContentTypeMode(*o.ContentType) {
+		if _, _, err := mime.ParseMediaType(*o.ContentType); err != nil {
+			return fmt.Errorf("invalid content_type %q, expected a mode or a media type", *o.ContentType)
+		}
+	}
+
+	if o.CacheControl != nil && !validHeader("Cache-Control", *o.CacheControl) {
+		return errors.New("invalid cache_control")
+	}
+
+	if o.Rate != nil {
+		if _, err := parseSize(*o.Rate); err != nil {
+			return fmt.Errorf("invalid rate: %w", err)
+		}
+	}
+
+	if o.Timeout != nil {
+		d, err := time.ParseDuration(*o.Timeout)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout %q", *o.Timeout)
+		}
+	}
+
+	if o.Checksum != nil {
+		if _, err := parseChecksum(*o.Checksum); err != nil {
+			return fmt.Errorf("invalid checksum: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// apply overrides the directives with the options, except the headers, see Plugin.optionHeaders
+func (o *options) apply(d *directives) {
+	if len(o.URLs) > 0 {
+		d.urls = o.URLs
+	}
+
+	if o.Filename != nil {
+		d.filename = *o.Filename
+	}
+
+	if o.ContentType != nil {
+		if validContentTypeMode(*o.ContentType) {
+			d.contentTypeMode = *o.ContentType
+		} else {
+			d.contentTypeMode = contentTypeForced
+			d.contentType = *o.ContentType
+		}
+	}
+
+	if o.Disposition != nil {
+		d.disposition = *o.Disposition
+	}
+
+	if o.CacheControl != nil {
+		d.cacheControl = *o.CacheControl
+	}
+
+	if o.Rate != nil {
+		// already validated
+		d.rate, _ = parseSize(*o.Rate)
+	}
+
+	if o.Timeout != nil {
+		d.timeout, _ = time.ParseDuration(*o.Timeout)
+	}
+
+	if o.Checksum != nil {
+		d.checksum, _ = parseChecksum(*o.Checksum)
+	}
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+
+	return nil, errors.New("invalid base64")
+}
+
+// validHeader rejects the header names which are not tokens and the values with the control characters (CRLF injection)
+func validHeader(name string, value string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("\"(),/:;<=>?@[\\]{}", c) >= 0 {
+			return false
+		}
+	}
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
+
+// setUpstreamHeaders sets the headers on the upstream request, the Host header overrides the request host
+func setUpstreamHeaders(req *http.Request, h http.Header) {
+	for k, v := range h {
+		if http.CanonicalHeaderKey(k) == "Host" {
+			if len(v) > 0 {
+				req.Host = v[0]
+			}
+			continue
+		}
+
+		req.Header[k] = v
+	}
+}
@@ -0,0 +1,108 @@
+package sendremotefile
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeOptions(t *testing.T) {
+	all := make(map[string]struct{}, len(knownOptions))
+	for _, o := range knownOptions {
+		all[o] = struct{}{}
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		allowed map[string]struct{}
+		err     bool
+	}{
+		{name: "json", value: `{"urls":["http://a/f"],"rate":"2MB","timeout":"10s"}`, allowed: all},
+		{name: "base64", value: base64.StdEncoding.EncodeToString([]byte(`{"filename":"f.txt"}`)), allowed: all},
+		{name: "raw url base64", value: base64.RawURLEncoding.EncodeToString([]byte(`{"disposition":"inline"}`)), allowed: all},
+		{name: "empty object", value: `{}`},
+		{name: "not allowed", value: `{"urls":["http://a/f"]}`, allowed: map[string]struct{}{optionFilename: {}}, err: true},
+		{name: "unknown", value: `{"foo":1}`, allowed: all, err: true},
+		{name: "not an object", value: `[1]`, allowed: all, err: true},
+		{name: "not base64", value: `*`, allowed: all, err: true},
+		{name: "wrong type", value: `{"rate":2}`, allowed: all, err: true},
+		{name: "empty urls", value: `{"urls":[]}`, allowed: all, err: true},
+		{name: "header injection", value: `{"headers":{"X-A":"a\r\nX-B: b"}}`, allowed: all, err: true},
+		{name: "invalid header name", value: `{"headers":{"X A":"a"}}`, allowed: all, err: true},
+		{name: "invalid disposition", value: `{"disposition":"download"}`, allowed: all, err: true},
+		{name: "content type mode", value: `{"content_type":"sniff"}`, allowed: all},
+		{name: "media type", value: `{"content_type":"text/plain; charset=utf-8"}`, allowed: all},
+		{name: "invalid media type", value: `{"content_type":"text/"}`, allowed: all, err: true},
+		{name: "invalid rate", value: `{"rate":"fast"}`, allowed: all, err: true},
+		{name: "negative timeout", value: `{"timeout":"-1s"}`, allowed: all, err: true},
+		{name: "invalid checksum", value: `{"checksum":"md5=zz"}`, allowed: all, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeOptions(tt.value, tt.allowed)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestOptionsApply(t *testing.T) {
+	opts, err := decodeOptions(`{"urls":["http://a/f","http://b/f"],"filename":"f.txt","content_type":"text/csv",`+
+		`"disposition":"inline","cache_control":"no-store","rate":"1KB","timeout":"3s","headers":{"Authorization":"x"}}`,
+		map[string]struct{}{
+			optionURLs: {}, optionFilename: {}, optionContentType: {}, optionDisposition: {},
+			optionCacheControl: {}, optionRate: {}, optionTimeout: {}, optionHeaders: {},
+		})
+	require.NoError(t, err)
+
+	d := &directives{urls: []string{"http://c/f"}, headers: http.Header{}}
+	opts.apply(d)
+
+	assert.Equal(t, []string{"http://a/f", "http://b/f"}, d.urls)
+	assert.Equal(t, "f.txt", d.filename)
+	assert.Equal(t, contentTypeForced, d.contentTypeMode)
+	assert.Equal(t, "text/csv", d.contentType)
+	assert.Equal(t, dispositionInline, d.disposition)
+	assert.Equal(t, "no-store", d.cacheControl)
+	assert.Equal(t, int64(1024), d.rate)
+	assert.Equal(t, 3*time.Second, d.timeout)
+	// the headers go through the allowlist
+	assert.Empty(t, d.headers)
+}
+
+func TestOptionHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		allowed  []string
+		headers  map[string]string
+		upstream http.Header
+	}{
+		{name: "none allowed", headers: map[string]string{"Authorization": "x"}, upstream: http.Header{}},
+		{
+			name:     "allowed",
+			allowed:  []string{"authorization"},
+			headers:  map[string]string{"authorization": "x", "Cookie": "c"},
+			upstream: http.Header{"Authorization": {"x"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPlugin(t, &Config{ForwardWorkerHeaders: tt.allowed})
+
+			h := http.Header{}
+			p.optionHeaders(tt.headers, h)
+			assert.Equal(t, tt.upstream, h)
+		})
+	}
+}
@@ -1,27 +1,35 @@
 package sendremotefile
 
 import (
+	"bytes"
 	"errors"
+	"html/template"
 	"io"
 	"maps"
-	"net"
+	"math"
 	"net/http"
-	"strings"
+	"strconv"
 	"time"
 
 	rrErrors "github.com/roadrunner-server/errors"
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 )
 
 const (
-	rootPluginName         string        = "http"
-	pluginName             string        = "sendremotefile"
-	responseContentTypeKey string        = "Content-Type"
-	responseContentTypeVal string        = "application/octet-stream"
-	responseStatusCode     int           = http.StatusOK
-	xSendRemoteHeader      string        = "X-Sendremotefile"
-	defaultBufferSize      uint          = TenMB
-	timeout                time.Duration = 5 * time.Second
+	rootPluginName            string        = "http"
+	pluginName                string        = "sendremotefile"
+	configKey                 string        = rootPluginName + "." + pluginName
+	responseContentTypeKey    string        = "Content-Type"
+	responseContentTypeVal    string        = "application/octet-stream"
+	responseStatusCode        int           = http.StatusOK
+	xSendRemoteHeader         string        = "X-Sendremotefile"
+	xSendRemoteRateHeader     string        = "X-Sendremotefile-Rate"
+	xSendRemoteFallbackHeader string        = "X-Sendremotefile-Fallback"
+	retryAfterHeader          string        = "Retry-After"
+	cacheControlHeader        string        = "Cache-Control"
+	defaultBufferSize         uint          = TenMB
+	timeout                   time.Duration = 5 * time.Second
 )
 
 type Configurer interface {
@@ -34,9 +42,31 @@ type Logger interface {
 }
 
 type Plugin struct {
+	cfg         *Config
 	log         *zap.Logger
+	redact      *redactor
 	bytesPool   *bpool
 	writersPool *wpool
+	metrics     *statsExporter
+	prober      *prober
+	breakers    *breakers
+	limiter     *limiter
+	hedge       *hedger
+	templates   map[int]*template.Template
+	// allowedOptions are the X-Sendremotefile-Options keys the worker may set
+	allowedOptions map[string]struct{}
+	// forwardWorkerHeaders are the canonical header names the worker may send to the upstream
+	forwardWorkerHeaders map[string]struct{}
+	// globalRate limits the bandwidth of all transfers
+	globalRate *rate.Limiter
+	// transferRate is the bytes/sec cap of a single transfer
+	transferRate int64
+	// minThroughput is the minimum average bytes/sec of a transfer
+	minThroughput int64
+	// maxObjectSize is the upstream object size limit, bytes
+	maxObjectSize int64
+	// client are the upstream client policies
+	client *clientOptions
 }
 
 func (p *Plugin) Init(cfg Configurer, log Logger) error {
@@ -46,9 +76,87 @@ func (p *Plugin) Init(cfg Configurer, log Logger) error {
 		return rrErrors.E(op, rrErrors.Disabled)
 	}
 
+	p.cfg = &Config{}
+	if cfg.Has(configKey) {
+		err := cfg.UnmarshalKey(configKey, p.cfg)
+		if err != nil {
+			return rrErrors.E(op, err)
+		}
+	}
+
+	p.cfg.InitDefaults()
+	err := p.cfg.Valid()
+	if err != nil {
+		return rrErrors.E(op, err)
+	}
+
 	p.log = log.NamedLogger(pluginName)
+	p.redact = newRedactor(p.cfg)
+	tlsConfigs, err := newTLSConfigs(p.cfg.TLS, p.log)
+	if err != nil {
+		return rrErrors.E(op, err)
+	}
+
+	p.client = &clientOptions{
+		redirects: p.cfg.Redirects,
+		tls:       tlsConfigs,
+		hosts:     newHostPolicy(p.cfg.Hosts),
+	}
+
 	p.bytesPool = NewBytePool()
 	p.writersPool = NewWriterPool()
+	p.metrics = newStatsExporter()
+	p.prober = newProber(p.cfg.Health, p.log, p.redact, p.metrics, p.client)
+	p.breakers = newBreakers(p.cfg.CircuitBreaker, p.log, p.metrics)
+	p.limiter = newLimiter(p.cfg.Limits)
+	p.hedge = newHedger(p.cfg.Hedging)
+
+	p.allowedOptions = make(map[string]struct{}, len(p.cfg.Options.Allowed))
+	for _, o := range p.cfg.Options.Allowed {
+		p.allowedOptions[o] = struct{}{}
+	}
+
+	p.forwardWorkerHeaders = make(map[string]struct{}, len(p.cfg.ForwardWorkerHeaders))
+	for _, k := range p.cfg.ForwardWorkerHeaders {
+		p.forwardWorkerHeaders[http.CanonicalHeaderKey(k)] = struct{}{}
+	}
+
+	p.templates, err = parseTemplates(p.cfg.Errors.Templates)
+	if err != nil {
+		return rrErrors.E(op, err)
+	}
+
+	if p.cfg.Throttle.Global != "" {
+		bps, err := parseSize(p.cfg.Throttle.Global)
+		if err != nil {
+			return rrErrors.E(op, err)
+		}
+		p.globalRate = newRateLimiter(bps)
+	}
+
+	if p.cfg.Throttle.PerTransfer != "" {
+		bps, err := parseSize(p.cfg.Throttle.PerTransfer)
+		if err != nil {
+			return rrErrors.E(op, err)
+		}
+		p.transferRate = bps
+	}
+
+	if p.cfg.SlowClient.MinThroughput != "" {
+		bps, err := parseSize(p.cfg.SlowClient.MinThroughput)
+		if err != nil {
+			return rrErrors.E(op, err)
+		}
+		p.minThroughput = bps
+	}
+
+	if p.cfg.MaxObjectSize != "" {
+		size, err := parseSize(p.cfg.MaxObjectSize)
+		if err != nil {
+			return rrErrors.E(op, err)
+		}
+		p.maxObjectSize = size
+	}
 
 	return nil
 }
@@ -61,107 +169,180 @@ func (p *Plugin) Middleware(next http.Handler) http.Handler {
 			_ = r.Body.Close()
 		}()
 
+		workerStart := time.Now()
 		next.ServeHTTP(rrWriter, r)
+		workerDuration := time.Since(workerStart)
 
 		// if there is no X-Sendremotefile header from the PHP worker, just return
-		if url := rrWriter.Header().Get(xSendRemoteHeader); url == "" {
-			// re-add original headers, status code and body
-			maps.Copy(w.Header(), rrWriter.Header())
-			w.WriteHeader(rrWriter.code)
-			if len(rrWriter.data) > 0 {
-				// write a body if exists
-				_, err := w.Write(rrWriter.data)
-				if err != nil {
-					p.log.Error("failed to write data to the response", zap.Error(err))
-				}
-			}
-
+		if rrWriter.Header().Get(xSendRemoteHeader) == "" && rrWriter.Header().Get(xSendRemoteOptionsHeader) == "" {
+			p.writeWorkerResponse(w, rrWriter)
 			return
 		}
 
-		// we already checked that that header exists
-		url := rrWriter.Header().Get(xSendRemoteHeader)
-		// delete the original X-Sendremotefile header
-		rrWriter.Header().Del(xSendRemoteHeader)
+		// we already checked that that header exists, collect the directives and delete their headers
+		d, derr := p.parseDirectives(rrWriter.Header())
+		d.clientHeaders = p.clientHeaders(r)
+		urls := d.urls
+		if len(urls) == 0 {
+			// the header is blank, let the validation below reject it
+			urls = append(urls, "")
+		}
+
+		t := newTransfer(urls[0], rrWriter.code)
+		t.worker = workerDuration
+		t.requestID = requestID(r)
+		t.rate = newRateLimiter(transferRate(p.transferRate, d.rate))
+		ctx, span := startSpan(r, urls[0])
 
-		if !strings.HasPrefix(url, "http") {
-			p.log.Error("header value must start with http")
-			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		p.metrics.inFlight.Inc()
+		defer func() {
+			p.metrics.inFlight.Dec()
+			p.metrics.observe(t)
+			endSpan(span, t)
+			p.logTransfer(t)
+		}()
+
+		if derr != nil {
+			p.log.Error("invalid options header", zap.Error(derr))
+			t.outcome = outcomeInvalidHeader
+			p.serverTiming(w, t, false)
+			p.writeError(w, r, t, http.StatusInternalServerError, errCodeInvalidOptions, detailInvalidOptions)
 			return
 		}
 
-		resp, err := NewClient(url, timeout).Request()
-		if err != nil {
-			var netErr net.Error
-			if errors.As(err, &netErr) && netErr.Timeout() {
-				http.Error(w, http.StatusText(http.StatusRequestTimeout), http.StatusRequestTimeout)
-				return
-			}
+		if err := checkURL(urls[0], p.client.hosts); err != nil {
+			p.log.Error("invalid upstream URL", p.redact.URL("url", urls[0]), p.redact.Error(err))
+			t.outcome = outcomeInvalidHeader
+			p.serverTiming(w, t, false)
+			p.writeError(w, r, t, http.StatusNotFound, errCodeURLRejected, detailURLRejected)
+			return
+		}
 
-			p.log.Error("failed to request from the upstream", zap.Error(err))
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		urls = p.validMirrors(urls)
+
+		resp, release, fe := p.fetch(ctx, t, d, urls)
+		if fe != nil {
+			p.fetchFailedOrFallback(w, r, rrWriter, t, fe)
 			return
 		}
 
 		defer func() {
-			err = resp.Body.Close()
+			release()
+			err := resp.Body.Close()
 			if err != nil {
-				p.log.Error("failed to close upstream response body", zap.Error(err))
+				p.log.Error("failed to close upstream response body", p.redact.URL("url", t.url), p.redact.Error(err))
 			}
 		}()
 
-		if resp.StatusCode != http.StatusOK {
-			p.log.Error("invalid upstream response status code", zap.Int("rr_response_code", rrWriter.code), zap.Int("remotefile_response_code", resp.StatusCode))
-			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
-			return
-		}
-
 		var pl = defaultBufferSize
 		if cl := resp.ContentLength; cl > 0 {
 			pl = uint(cl)
 		}
 
 		pb := p.bytesPool.get(pl)
+		defer p.bytesPool.put(pl, pb)
+
+		mode := p.cfg.ContentType.Mode
+		if d.contentTypeMode != "" {
+			mode = d.contentTypeMode
+		}
+
+		// the upstream encoding is passed as is, when the client Accept-Encoding is forwarded
+		encoding := resp.Header.Get(contentEncodingHeader)
+
+		var body io.Reader = resp.Body
+		var head []byte
+		// the encoded body can't be sniffed
+		if mode == contentTypeSniff && encoding == "" {
+			head = make([]byte, sniffLen)
+			n, err := io.ReadFull(resp.Body, head)
+			if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				p.log.Error("failed to read data from the upstream response", p.redact.URL("url", t.url), p.redact.Error(err))
+				// nothing is sent yet
+				p.fetchFailedOrFallback(w, r, rrWriter, t, &fetchError{outcome: outcomeTruncated, err: err})
+				return
+			}
+
+			head = head[:n]
+			body = io.MultiReader(bytes.NewReader(head), resp.Body)
+		}
 
 		// re-add original headers
 		maps.Copy(w.Header(), rrWriter.Header())
 		// overwrite content-type header
-		w.Header().Set(responseContentTypeKey, responseContentTypeVal)
-		w.WriteHeader(responseStatusCode)
-
-		rc := http.NewResponseController(w)
+		w.Header().Set(responseContentTypeKey, p.contentType(mode, rrWriter.Header().Get(responseContentTypeKey), resp.Header.Get(responseContentTypeKey), head))
+		if d.contentType != "" && mode == contentTypeForced {
+			w.Header().Set(responseContentTypeKey, d.contentType)
+		}
+		if encoding != "" {
+			w.Header().Set(contentEncodingHeader, encoding)
+			w.Header().Add(varyHeader, "Accept-Encoding")
+		}
+		if d.cacheControl != "" {
+			w.Header().Set(cacheControlHeader, d.cacheControl)
+		}
+		if d.filename != "" || d.disposition != "" {
+			w.Header().Set(contentDispositionHeader, contentDisposition(d.disposition, d.filename))
+		}
+		// the worker checksum takes precedence, the upstream one is about the stored object, which might be
+		// encoded (and transparently decompressed by the transport)
+		t.checksum = d.checksum
+		if t.checksum == nil && encoding == "" && !resp.Uncompressed {
+			t.checksum = upstreamChecksum(resp.Header)
+		}
 
-		for {
-			nr, er := resp.Body.Read(*pb)
+		if p.cfg.Digest {
+			setDigest(w.Header(), t, encoding != "")
+		}
 
-			if nr > 0 {
-				nw, ew := w.Write((*pb)[:nr])
+		p.serverTiming(w, t, true)
+		w.WriteHeader(responseStatusCode)
 
-				if nw > 0 {
-					if ef := rc.Flush(); ef != nil {
-						p.log.Error("failed to flush data to the downstream response", zap.Error(ef))
-						break
-					}
-				}
+		p.stream(ctx, w, body, *pb, t)
+		if p.cfg.ServerTiming {
+			setServerTimingTrailer(w.Header(), t)
+		}
+		if p.cfg.Digest {
+			setDigestTrailer(w.Header(), t)
+		}
+	})
+}
 
-				if ew != nil {
-					p.log.Error("failed to write data to the downstream response", zap.Error(ew))
-					break
-				}
-			}
+// fetchFailedOrFallback sends the worker response instead of the error response, if the worker fallback is enabled
+func (p *Plugin) fetchFailedOrFallback(w http.ResponseWriter, r *http.Request, rrWriter *writer, t *transfer, fe *fetchError) {
+	if p.cfg.WorkerFallback && fe.outcome != outcomeClientAbort {
+		p.log.Warn("upstream fetch failed, sending the worker response", p.redact.URL("url", t.url), zap.String("reason", fe.outcome))
+		t.outcome = fe.outcome
+		p.serverTiming(w, t, false)
+		p.writeWorkerResponse(w, rrWriter)
+		return
+	}
 
-			if er == io.EOF {
-				break
-			}
+	p.fetchFailed(w, r, t, fe)
+}
 
-			if er != nil {
-				p.log.Error("failed to read data from the upstream response", zap.Error(er))
-				break
-			}
+// writeWorkerResponse sends the original headers, status code and body of the worker response
+func (p *Plugin) writeWorkerResponse(w http.ResponseWriter, rrWriter *writer) {
+	maps.Copy(w.Header(), rrWriter.Header())
+	w.WriteHeader(rrWriter.code)
+	if len(rrWriter.data) > 0 {
+		// write a body if exists
+		_, err := w.Write(rrWriter.data)
+		if err != nil {
+			p.log.Error("failed to write data to the response", zap.Error(err))
 		}
+	}
+}
 
-		p.bytesPool.put(pl, pb)
-	})
+// retryAfter formats the duration as the Retry-After seconds, at least 1
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(max(int(math.Ceil(d.Seconds())), 1))
+}
+
+func (p *Plugin) serverTiming(w http.ResponseWriter, t *transfer, streamed bool) {
+	if p.cfg.ServerTiming {
+		setServerTiming(w.Header(), t, streamed)
+	}
 }
 
 // Middleware/plugin name.
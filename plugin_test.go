@@ -0,0 +1,114 @@
+package sendremotefile
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type testConfigurer struct {
+	cfg *Config
+}
+
+func (c *testConfigurer) UnmarshalKey(_ string, out any) error {
+	*(out.(*Config)) = *c.cfg
+	return nil
+}
+
+func (c *testConfigurer) Has(string) bool {
+	return true
+}
+
+type testLogger struct{}
+
+func (testLogger) NamedLogger(string) *zap.Logger {
+	return zap.NewNop()
+}
+
+func newTestPlugin(t *testing.T, cfg *Config) *Plugin {
+	t.Helper()
+
+	p := &Plugin{}
+	require.NoError(t, p.Init(&testConfigurer{cfg: cfg}, testLogger{}))
+
+	return p
+}
+
+// serve passes the request through the middleware, the worker sets the response headers
+func serve(p *Plugin, worker http.Header, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		for k, v := range worker {
+			w.Header()[k] = v
+		}
+	})).ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestWorkerFallback(t *testing.T) {
+	// stalled sends the headers and a part of the body, then stalls past the upstream timeout
+	stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1024")
+		_, _ = w.Write([]byte("part"))
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer stalled.Close()
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+
+	tests := []struct {
+		name     string
+		url      string
+		fallback bool
+		status   int
+	}{
+		{name: "upstream status", url: notFound.URL, fallback: true, status: http.StatusTeapot},
+		{name: "sniff read error", url: stalled.URL, fallback: true, status: http.StatusTeapot},
+		{name: "disabled", url: notFound.URL, status: http.StatusNotFound},
+		{name: "disabled sniff read error", url: stalled.URL, status: http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPlugin(t, &Config{
+				WorkerFallback: tt.fallback,
+				ContentType:    &ContentTypeConfig{Mode: contentTypeSniff},
+				Options:        &OptionsConfig{Allowed: []string{optionURLs, optionTimeout}},
+			})
+
+			rec := httptest.NewRecorder()
+			p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set(xSendRemoteOptionsHeader, `{"urls":["`+tt.url+`"],"timeout":"100ms"}`)
+				w.Header().Set(xSendRemoteFilenameHeader, "file.txt")
+				w.Header().Set(xSendRemoteUpstreamPrefix+"Authorization", "secret")
+				w.Header().Set("X-Worker", "1")
+				w.WriteHeader(http.StatusTeapot)
+				_, _ = w.Write([]byte("worker body"))
+			})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			assert.Equal(t, tt.status, rec.Code)
+			if !tt.fallback {
+				assert.Empty(t, rec.Header().Get("X-Worker"))
+				return
+			}
+
+			assert.Equal(t, "worker body", rec.Body.String())
+			assert.Equal(t, "1", rec.Header().Get("X-Worker"))
+			for k := range rec.Header() {
+				assert.NotContains(t, k, xSendRemoteHeader)
+			}
+		})
+	}
+}
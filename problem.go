@@ -0,0 +1,218 @@
+package sendremotefile
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	acceptHeader          string = "Accept"
+	requestIDHeader       string = "X-Request-Id"
+	problemContentType    string = "application/problem+json"
+	templateContentType   string = "text/html; charset=utf-8"
+	problemTypeNamespace  string = "urn:sendremotefile:error:"
+	errCodeURLRejected    string = "url_rejected"
+	errCodeTimeout        string = "upstream_timeout"
+	errCodeUnreachable    string = "upstream_unreachable"
+	errCodeNotFound       string = "upstream_not_found"
+	errCodeGone           string = "upstream_gone"
+	errCodeForbidden      string = "upstream_forbidden"
+	errCodeUpstream       string = "upstream_error"
+	errCodeUnavailable    string = "upstream_unavailable"
+	errCodeOverloaded     string = "overloaded"
+	errCodeInvalidOptions string = "invalid_options"
+	errCodeTooLarge       string = "object_too_large"
+	errCodeContentType    string = "content_type_not_allowed"
+	detailURLRejected     string = "The file location provided by the application is not valid."
+	detailUpstreamTimeout string = "The storage did not respond in time."
+	detailUpstreamFailed  string = "The file could not be retrieved from the storage."
+	detailUnavailable     string = "The storage is temporarily unavailable."
+	detailInvalidOptions  string = "The transfer options provided by the application are not valid."
+	detailTooLarge        string = "The file is larger than allowed."
+	detailContentType     string = "The file type is not allowed."
+)
+
+// problem is the RFC 9457 problem details object, also used as the error templates data
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// parseTemplates parses the configured error templates, the keys are the status codes
+func parseTemplates(files map[string]string) (map[int]*template.Template, error) {
+	templates := make(map[int]*template.Template, len(files))
+	for k, file := range files {
+		code, err := strconv.Atoi(k)
+		if err != nil || code < 400 || code > 599 {
+			return nil, fmt.Errorf("invalid errors.templates key %q, expected a 4xx or 5xx status code", k)
+		}
+
+		tpl, err := template.ParseFiles(file)
+		if err != nil {
+			return nil, err
+		}
+
+		templates[code] = tpl
+	}
+
+	return templates, nil
+}
+
+// writeError sends the error response: problem+json for the clients preferring JSON (if enabled),
+// the configured template for the status code, or the plain text otherwise. The request ID is sent
+// in the X-Request-Id header and logged with the failure.
+func (p *Plugin) writeError(w http.ResponseWriter, r *http.Request, t *transfer, code int, errCode string, detail string) {
+	pr := &problem{
+		Type:      problemTypeNamespace + errCode,
+		Title:     http.StatusText(code),
+		Status:    code,
+		Detail:    detail,
+		Code:      errCode,
+		RequestID: t.requestID,
+	}
+
+	p.log.Info("transfer failed",
+		zap.String("request_id", t.requestID),
+		p.redact.URL("url", t.url),
+		zap.Int("status", code),
+		zap.String("code", errCode),
+		zap.String("reason", t.outcome),
+	)
+	w.Header().Set(requestIDHeader, t.requestID)
+
+	tpl, hasTemplate := p.templates[code]
+	alternative := "text/plain"
+	if hasTemplate {
+		alternative = "text/html"
+	}
+
+	if p.cfg.Errors.ProblemJSON && acceptsJSON(r.Header.Get(acceptHeader), alternative) {
+		body, err := json.Marshal(pr)
+		if err == nil {
+			w.Header().Set(responseContentTypeKey, problemContentType)
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.WriteHeader(code)
+			_, _ = w.Write(body)
+			return
+		}
+
+		p.log.Error("failed to marshal the problem details", zap.Error(err))
+	}
+
+	if hasTemplate {
+		buf := new(bytes.Buffer)
+		err := tpl.Execute(buf, pr)
+		if err == nil {
+			w.Header().Set(responseContentTypeKey, templateContentType)
+			w.WriteHeader(code)
+			_, _ = w.Write(buf.Bytes())
+			return
+		}
+
+		p.log.Error("failed to execute the error template", zap.Int("status", code), zap.Error(err))
+	}
+
+	http.Error(w, http.StatusText(code), code)
+}
+
+// acceptsJSON reports whether the Accept header explicitly lists problem+json or JSON, and prefers it
+// at least as much as the alternative media type
+func acceptsJSON(accept string, alternative string) bool {
+	var q float64
+	for _, mt := range []string{problemContentType, "application/json"} {
+		if v, exact := acceptQuality(accept, mt); exact {
+			q = max(q, v)
+		}
+	}
+
+	if q == 0 {
+		return false
+	}
+
+	alt, _ := acceptQuality(accept, alternative)
+
+	return q >= alt
+}
+
+// acceptQuality returns the q-value the Accept header gives the media type, the most specific matching range
+// (`type/subtype`, `type/*`, `*/*`) decides. The second value reports the exact match.
+func acceptQuality(accept string, mediaType string) (float64, bool) {
+	if strings.TrimSpace(accept) == "" {
+		// anything is acceptable
+		return 1, false
+	}
+
+	typ, _, _ := strings.Cut(mediaType, "/")
+	best, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		s := -1
+		switch mt {
+		case mediaType:
+			s = 2
+		case typ + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+
+		if s > specificity {
+			best, specificity = q, s
+		}
+	}
+
+	return best, specificity == 2
+}
+
+// requestID returns the client provided request ID or a random one, the same for all the transfer log entries
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" {
+		return id
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// upstreamErrCode returns the error code for the upstream response status
+func upstreamErrCode(status int) string {
+	switch status {
+	case http.StatusNotFound:
+		return errCodeNotFound
+	case http.StatusGone:
+		return errCodeGone
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return errCodeForbidden
+	default:
+		return errCodeUpstream
+	}
+}
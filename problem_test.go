@@ -0,0 +1,70 @@
+package sendremotefile
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptsJSON(t *testing.T) {
+	tests := []struct {
+		accept      string
+		alternative string
+		want        bool
+	}{
+		{accept: "", alternative: "text/plain", want: false},
+		{accept: "*/*", alternative: "text/plain", want: false},
+		{accept: "application/json", alternative: "text/plain", want: true},
+		{accept: "application/problem+json", alternative: "text/html", want: true},
+		{accept: "application/*", alternative: "text/plain", want: false},
+		{accept: "text/html, application/json;q=0.1", alternative: "text/html", want: false},
+		{accept: "text/html;q=0.5, application/json", alternative: "text/html", want: true},
+		{accept: "application/json, */*;q=0.8", alternative: "text/html", want: true},
+		{accept: "text/*, application/json;q=0.9", alternative: "text/plain", want: false},
+		{accept: "application/json;q=0", alternative: "text/plain", want: false},
+		{accept: "application/json, text/html", alternative: "text/html", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			assert.Equal(t, tt.want, acceptsJSON(tt.accept, tt.alternative))
+		})
+	}
+}
+
+func TestWriteErrorRequestID(t *testing.T) {
+	p := newTestPlugin(t, &Config{Errors: &ErrorsConfig{ProblemJSON: true}})
+
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{name: "client provided", header: "abc-123"},
+		{name: "generated"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(acceptHeader, "application/json")
+			if tt.header != "" {
+				req.Header.Set(requestIDHeader, tt.header)
+			}
+
+			rec := serve(p, http.Header{xSendRemoteHeader: {"ftp://storage/file"}}, req)
+			require.Equal(t, http.StatusNotFound, rec.Code)
+
+			var pr problem
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pr))
+			assert.NotEmpty(t, pr.RequestID)
+			assert.Equal(t, pr.RequestID, rec.Header().Get(requestIDHeader))
+			if tt.header != "" {
+				assert.Equal(t, tt.header, pr.RequestID)
+			}
+		})
+	}
+}
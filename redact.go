@@ -0,0 +1,96 @@
+package sendremotefile
+
+import (
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const redacted string = "REDACTED"
+
+// redactor hides presigned URL secrets before they reach the logs
+type redactor struct {
+	params   map[string]struct{}
+	all      bool
+	disabled bool
+}
+
+func newRedactor(cfg *Config) *redactor {
+	r := &redactor{
+		params:   make(map[string]struct{}, len(cfg.RedactQueryParams)),
+		disabled: cfg.LogFullURLs,
+	}
+
+	for _, p := range cfg.RedactQueryParams {
+		if p == "*" {
+			r.all = true
+			continue
+		}
+
+		r.params[strings.ToLower(p)] = struct{}{}
+	}
+
+	return r
+}
+
+// Error returns a zap error field with the URL redacted, net/http client errors embed the full request URL
+func (r *redactor) Error(err error) zap.Field {
+	if ue, ok := err.(*url.Error); ok { //nolint:errorlint
+		cp := *ue
+		cp.URL = r.redact(ue.URL)
+		return zap.Error(&cp)
+	}
+
+	return zap.Error(err)
+}
+
+// URL returns a zap field with the redacted URL, every log line with an upstream URL should use it
+func (r *redactor) URL(key string, raw string) zap.Field {
+	return zap.String(key, r.redact(raw))
+}
+
+func (r *redactor) redact(raw string) string {
+	if r.disabled {
+		return raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		// we can't tell which part holds the secrets
+		return redacted
+	}
+
+	// user:password@
+	u.User = nil
+
+	if u.RawQuery == "" {
+		return u.String()
+	}
+
+	query := u.Query()
+	for k := range query {
+		if _, ok := r.params[strings.ToLower(k)]; ok || r.all {
+			query[k] = []string{redacted}
+		}
+	}
+
+	// Encode sorts the keys, which is fine for the logs
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// hostPath returns only the host and the path of the URL
+func (r *redactor) hostPath(raw string) string {
+	if r.disabled {
+		return raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redacted
+	}
+
+	return u.Host + u.Path
+}
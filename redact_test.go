@@ -0,0 +1,55 @@
+package sendremotefile
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name   string
+		params []string
+		full   bool
+		raw    string
+		out    string
+	}{
+		{name: "no query", params: []string{"sig"}, raw: "https://a.com/f", out: "https://a.com/f"},
+		{name: "credentials", params: []string{"sig"}, raw: "https://u:p@a.com/f", out: "https://a.com/f"},
+		{
+			name:   "case-insensitive",
+			params: []string{"X-Amz-Signature"},
+			raw:    "https://a.com/f?x-amz-signature=secret&part=1",
+			out:    "https://a.com/f?part=1&x-amz-signature=" + redacted,
+		},
+		{name: "all", params: []string{"*"}, raw: "https://a.com/f?a=1&b=2", out: "https://a.com/f?a=" + redacted + "&b=" + redacted},
+		{name: "malformed", params: []string{"sig"}, raw: "https://a.com/%zz?sig=secret", out: redacted},
+		{name: "full urls", params: []string{"sig"}, full: true, raw: "https://u:p@a.com/f?sig=secret", out: "https://u:p@a.com/f?sig=secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRedactor(&Config{RedactQueryParams: tt.params, LogFullURLs: tt.full})
+			assert.Equal(t, tt.out, r.redact(tt.raw))
+		})
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	r := newRedactor(&Config{RedactQueryParams: []string{"sig"}})
+
+	ue := &url.Error{Op: "Get", URL: "https://a.com/f?sig=secret", Err: errors.New("timeout")}
+	f := r.Error(ue)
+
+	assert.Equal(t, `Get "https://a.com/f?sig=`+redacted+`": timeout`, f.Interface.(error).Error())
+	// the original error is not modified
+	assert.Equal(t, "https://a.com/f?sig=secret", ue.URL)
+}
+
+func TestHostPath(t *testing.T) {
+	r := newRedactor(&Config{})
+	assert.Equal(t, "a.com/dir/f", r.hostPath("https://u:p@a.com/dir/f?sig=secret"))
+	assert.Equal(t, redacted, r.hostPath("https://a.com/%zz"))
+}
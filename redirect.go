@@ -0,0 +1,76 @@
+package sendremotefile
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+var errInvalidURL = errors.New("URL must be absolute with the http or https scheme and a host")
+
+// validateURL checks the upstream URL and every redirect hop
+func validateURL(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errInvalidURL
+	}
+
+	return nil
+}
+
+// checkURL parses and validates the upstream URL, the host must be allowed by the policy
+func checkURL(raw string, hosts *hostPolicy) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	if err := validateURL(u); err != nil {
+		return err
+	}
+
+	return hosts.checkHost(u.Hostname())
+}
+
+// checkRedirect returns the redirect policy of the upstream client. Every hop is checked as the upstream URL,
+// the addresses are checked by the dialer. On the cross-host hops the headers set by the plugin (configured,
+// worker and forwarded client ones) are dropped unless allowed, net/http drops the Authorization and Cookie
+// headers on its own.
+func checkRedirect(cfg *RedirectsConfig, hosts *hostPolicy, header http.Header) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if cfg.Disabled {
+			// the redirect response is handled as any other non-200 upstream response
+			return http.ErrUseLastResponse
+		}
+
+		if len(via) >= cfg.Max {
+			return fmt.Errorf("stopped after %d redirects", cfg.Max)
+		}
+
+		if err := validateURL(req.URL); err != nil {
+			return fmt.Errorf("invalid redirect: %w", err)
+		}
+
+		if err := hosts.checkHost(req.URL.Hostname()); err != nil {
+			return fmt.Errorf("invalid redirect: %w", err)
+		}
+
+		if req.URL.Host == via[0].URL.Host {
+			return nil
+		}
+
+		if cfg.SameHost {
+			return fmt.Errorf("redirect to another host %q is not allowed", req.URL.Host)
+		}
+
+		for k := range header {
+			if !slices.ContainsFunc(cfg.CrossHostHeaders, func(a string) bool { return strings.EqualFold(a, k) }) {
+				req.Header.Del(k)
+			}
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,150 @@
+package sendremotefile
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckURL(t *testing.T) {
+	hosts := newHostPolicy(&HostsConfig{Allowed: []string{"storage.example.com", "*.cdn.example.com"}})
+
+	tests := []struct {
+		raw   string
+		hosts *hostPolicy
+		err   bool
+	}{
+		{raw: "http://storage.example.com/file"},
+		{raw: "https://storage.example.com:8443/reports/a,b.pdf"},
+		{raw: "ftp://storage.example.com/file", err: true},
+		{raw: "/local/file", err: true},
+		{raw: "http:///file", err: true},
+		{raw: "http://storage.example.com/file", hosts: hosts},
+		{raw: "http://STORAGE.example.com/file", hosts: hosts},
+		{raw: "http://eu.cdn.example.com/file", hosts: hosts},
+		{raw: "http://cdn.example.com.evil.com/file", hosts: hosts, err: true},
+		{raw: "http://evil.com/file", hosts: hosts, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			err := checkURL(tt.raw, tt.hosts)
+			if tt.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestHostPolicyCheckIP(t *testing.T) {
+	tests := []struct {
+		ip       string
+		networks []string
+		err      bool
+	}{
+		{ip: "93.184.215.14"},
+		{ip: "127.0.0.1", err: true},
+		{ip: "10.1.2.3", err: true},
+		{ip: "10.1.2.3", networks: []string{"10.1.0.0/16"}},
+		{ip: "10.2.2.3", networks: []string{"10.1.0.0/16"}, err: true},
+		{ip: "169.254.169.254", err: true},
+		{ip: "0.0.0.0", err: true},
+		{ip: "::1", err: true},
+		{ip: "fe80::1", err: true},
+		{ip: "fd00::1", err: true},
+		{ip: "::ffff:127.0.0.1", err: true},
+		{ip: "2606:2800:220:1::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			hp := newHostPolicy(&HostsConfig{DenyPrivate: true, AllowedNetworks: tt.networks})
+			err := hp.checkIP(net.ParseIP(tt.ip))
+			if tt.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	assert.NoError(t, newHostPolicy(&HostsConfig{}).checkIP(net.ParseIP("127.0.0.1")))
+}
+
+func TestCheckRedirect(t *testing.T) {
+	// other is a different host:port, it echoes the received headers
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "other key="+r.Header.Get("X-Api-Key")+" trace="+r.Header.Get("X-Trace"))
+	}))
+	defer other.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/same":
+			http.Redirect(w, r, "/file", http.StatusFound)
+		case "/cross":
+			http.Redirect(w, r, other.URL+"/file", http.StatusFound)
+		case "/ftp":
+			http.Redirect(w, r, "ftp://storage/file", http.StatusFound)
+		default:
+			_, _ = io.WriteString(w, "same key="+r.Header.Get("X-Api-Key"))
+		}
+	}))
+	defer upstream.Close()
+
+	header := http.Header{"X-Api-Key": {"secret"}, "X-Trace": {"t1"}}
+
+	tests := []struct {
+		name      string
+		redirects *RedirectsConfig
+		hosts     *hostPolicy
+		path      string
+		status    int
+		body      string
+		err       bool
+	}{
+		{name: "same host keeps the headers", redirects: &RedirectsConfig{Max: 10}, path: "/same", status: http.StatusOK, body: "same key=secret"},
+		{name: "cross host drops the headers", redirects: &RedirectsConfig{Max: 10, CrossHostHeaders: []string{"x-trace"}}, path: "/cross", status: http.StatusOK, body: "other key= trace=t1"},
+		{name: "too many redirects", redirects: &RedirectsConfig{Max: 3}, path: "/loop", err: true},
+		{name: "same host only", redirects: &RedirectsConfig{Max: 10, SameHost: true}, path: "/cross", err: true},
+		{name: "disabled", redirects: &RedirectsConfig{Disabled: true, Max: 10}, path: "/same", status: http.StatusFound},
+		{name: "invalid scheme", redirects: &RedirectsConfig{Max: 10}, path: "/ftp", err: true},
+		{name: "host not allowed", redirects: &RedirectsConfig{Max: 10}, hosts: newHostPolicy(&HostsConfig{Allowed: []string{"localhost"}}), path: "/cross", err: true},
+		{name: "private address", redirects: &RedirectsConfig{Max: 10}, hosts: newHostPolicy(&HostsConfig{DenyPrivate: true}), path: "/same", err: true},
+		{name: "allowed network", redirects: &RedirectsConfig{Max: 10}, hosts: newHostPolicy(&HostsConfig{DenyPrivate: true, AllowedNetworks: []string{"127.0.0.0/8"}}), path: "/cross", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &clientOptions{redirects: tt.redirects, hosts: tt.hosts}
+			resp, err := NewClient(upstream.URL+tt.path, time.Second, header, opts).Request(context.Background())
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			defer func() {
+				_ = resp.Body.Close()
+			}()
+
+			assert.Equal(t, tt.status, resp.StatusCode)
+			if tt.body != "" {
+				b, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, tt.body, string(b))
+			}
+		})
+	}
+}
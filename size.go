@@ -0,0 +1,47 @@
+package sendremotefile
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var errInvalidSize = errors.New("invalid size, expected a number with an optional B, KB, MB or GB suffix")
+
+// parseSize parses human-readable sizes like `512KB`, `2MB` or `1048576` (1KB = 1024 bytes)
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+
+	multiplier := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{
+		{"GB", 1024 * 1024 * 1024},
+		{"MB", 1024 * 1024},
+		{"KB", 1024},
+		{"G", 1024 * 1024 * 1024},
+		{"M", 1024 * 1024},
+		{"K", 1024},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			multiplier = u.mult
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, errInvalidSize
+	}
+
+	// the larger ones overflow the int64
+	if n >= math.MaxInt64/float64(multiplier) {
+		return 0, errInvalidSize
+	}
+
+	return int64(n * float64(multiplier)), nil
+}
@@ -0,0 +1,70 @@
+package sendremotefile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		size int64
+		err  bool
+	}{
+		{in: "1048576", size: 1048576},
+		{in: "0", size: 0},
+		{in: "512B", size: 512},
+		{in: "512KB", size: 512 * 1024},
+		{in: "2MB", size: 2 * 1024 * 1024},
+		{in: "1GB", size: 1024 * 1024 * 1024},
+		{in: "1.5M", size: 1536 * 1024},
+		{in: " 2 kb ", size: 2048},
+		{in: "4k", size: 4096},
+		{in: "", err: true},
+		{in: "MB", err: true},
+		{in: "-1KB", err: true},
+		{in: "2TB", err: true},
+		{in: "fast", err: true},
+		{in: "inf", err: true},
+		{in: "-Inf", err: true},
+		{in: "NaN", err: true},
+		{in: "1e30", err: true},
+		{in: "9223372036854775807", err: true},
+		{in: "8589934592GB", err: true},
+		{in: "8589934591GB", size: 8589934591 * 1024 * 1024 * 1024},
+		{in: "1e3KB", size: 1000 * 1024},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			n, err := parseSize(tt.in)
+			if tt.err {
+				assert.ErrorIs(t, err, errInvalidSize)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.size, n)
+		})
+	}
+}
+
+func TestInitInvalidSize(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *Config
+	}{
+		{name: "max object size", cfg: &Config{MaxObjectSize: "1e30"}},
+		{name: "global throttle", cfg: &Config{Throttle: &ThrottleConfig{Global: "inf"}}},
+		{name: "per transfer throttle", cfg: &Config{Throttle: &ThrottleConfig{PerTransfer: "NaN"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Plugin{}
+			assert.Error(t, p.Init(&testConfigurer{cfg: tt.cfg}, testLogger{}))
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package sendremotefile
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// stream copies the upstream response body to the client chunk by chunk
+func (p *Plugin) stream(ctx context.Context, w http.ResponseWriter, body io.Reader, buf []byte, t *transfer) {
+	rc := http.NewResponseController(w)
+	start := time.Now()
+
+	// the throttling waits and the blocked writes are bounded by the max duration too
+	reqCtx := ctx
+	var deadline time.Time
+	if p.cfg.SlowClient.MaxDuration > 0 {
+		deadline = t.start.Add(p.cfg.SlowClient.MaxDuration)
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	if p.cfg.SlowClient.WriteTimeout > 0 || !deadline.IsZero() {
+		// do not leave the deadline on the keep-alive connection
+		defer func() {
+			_ = rc.SetWriteDeadline(time.Time{})
+		}()
+	}
+
+	limiters := make([]*rate.Limiter, 0, 2)
+	if p.globalRate != nil {
+		limiters = append(limiters, p.globalRate)
+	}
+	if t.rate != nil {
+		limiters = append(limiters, t.rate)
+	}
+
+	// a chunk can't be larger than the token bucket
+	chunk := len(buf)
+	for _, l := range limiters {
+		chunk = min(chunk, l.Burst())
+	}
+
+	for {
+		nr, er := body.Read(buf[:chunk])
+
+		if nr > 0 {
+			p.checkStreamedSize(t, nr)
+
+			if t.checksum != nil {
+				t.checksum.h.Write(buf[:nr])
+			}
+			if t.digest != nil {
+				t.digest.Write(buf[:nr])
+			}
+
+			for _, l := range limiters {
+				if ew := l.WaitN(ctx, nr); ew != nil {
+					if reqCtx.Err() != nil {
+						// the request context is canceled
+						t.outcome = outcomeClientAbort
+						return
+					}
+
+					// the wait would pass the max duration
+					p.maxDurationExceeded(t)
+				}
+			}
+
+			if wd := p.writeDeadline(deadline); !wd.IsZero() {
+				if ed := rc.SetWriteDeadline(wd); ed != nil && !errors.Is(ed, http.ErrNotSupported) {
+					p.log.Error("failed to set the downstream write deadline", zap.Error(ed))
+				}
+			}
+
+			nw, ew := w.Write(buf[:nr])
+			t.written += int64(nw)
+
+			if nw > 0 {
+				if ef := rc.Flush(); ef != nil {
+					p.log.Error("failed to flush data to the downstream response", zap.Error(ef))
+					t.outcome = p.writeOutcome(ef, t)
+					return
+				}
+			}
+
+			if ew != nil {
+				p.log.Error("failed to write data to the downstream response", zap.Error(ew))
+				t.outcome = p.writeOutcome(ew, t)
+				return
+			}
+
+			p.checkSlowClient(t, start)
+		}
+
+		if er == io.EOF {
+			p.verifyChecksum(t)
+			return
+		}
+
+		if er != nil {
+			p.log.Error("failed to read data from the upstream response", p.redact.URL("url", t.url), p.redact.Error(er))
+			t.outcome = outcomeTruncated
+			// the digest headers promise the whole body, the client must see it is incomplete
+			if t.checksum != nil || t.digest != nil {
+				abort()
+			}
+			return
+		}
+	}
+}
+
+// checkSlowClient aborts the transfer which takes too long or is too slow
+func (p *Plugin) checkSlowClient(t *transfer, start time.Time) {
+	if p.cfg.SlowClient.MaxDuration > 0 && t.duration() > p.cfg.SlowClient.MaxDuration {
+		p.maxDurationExceeded(t)
+	}
+
+	if p.minThroughput <= 0 {
+		return
+	}
+
+	elapsed := time.Since(start)
+	if elapsed < p.cfg.SlowClient.GracePeriod {
+		return
+	}
+
+	if avg := float64(t.written) / elapsed.Seconds(); avg < float64(p.minThroughput) {
+		p.log.Warn("client is too slow, aborting", p.redact.URL("url", t.url), zap.Int64("written", t.written), zap.Float64("throughput_bytes_per_sec", avg))
+		t.outcome = outcomeSlowClient
+		abort()
+	}
+}
+
+func (p *Plugin) maxDurationExceeded(t *transfer) {
+	p.log.Warn("transfer exceeded the maximum duration, aborting", p.redact.URL("url", t.url), zap.Int64("written", t.written), zap.Duration("duration", t.duration()))
+	t.outcome = outcomeMaxDuration
+	abort()
+}
+
+// writeDeadline returns the earlier of the write timeout and the max duration deadline, zero if none is set
+func (p *Plugin) writeDeadline(deadline time.Time) time.Time {
+	if p.cfg.SlowClient.WriteTimeout > 0 {
+		if wd := time.Now().Add(p.cfg.SlowClient.WriteTimeout); deadline.IsZero() || wd.Before(deadline) {
+			return wd
+		}
+	}
+
+	return deadline
+}
+
+// writeOutcome tells the expired write deadline (the write timeout or the max duration) from the gone client
+func (p *Plugin) writeOutcome(err error, t *transfer) string {
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		return outcomeClientAbort
+	}
+
+	if p.cfg.SlowClient.MaxDuration > 0 && t.duration() >= p.cfg.SlowClient.MaxDuration {
+		return outcomeMaxDuration
+	}
+
+	return outcomeSlowClient
+}
+
+// abort closes the client connection without completing the response, so the client sees an incomplete body
+func abort() {
+	panic(http.ErrAbortHandler)
+}
@@ -0,0 +1,132 @@
+package sendremotefile
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the throttled transfer must not wait past the max duration
+func TestStreamMaxDurationThrottled(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(bytes.Repeat([]byte{'a'}, 256*1024))
+	}))
+	defer upstream.Close()
+
+	p := newTestPlugin(t, &Config{
+		Throttle:   &ThrottleConfig{PerTransfer: "32KB"},
+		SlowClient: &SlowClientConfig{MaxDuration: 300 * time.Millisecond},
+	})
+
+	tr := newTransfer(upstream.URL, http.StatusOK)
+	tr.rate = newRateLimiter(p.transferRate)
+
+	resp, err := http.Get(upstream.URL) //nolint:noctx
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	start := time.Now()
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		p.stream(context.Background(), httptest.NewRecorder(), resp.Body, make([]byte, 32*1024), tr)
+	})
+
+	assert.Equal(t, outcomeMaxDuration, tr.outcome)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestWriteDeadline(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name         string
+		writeTimeout time.Duration
+		deadline     time.Time
+		want         func(time.Time) bool
+	}{
+		{name: "none", want: time.Time.IsZero},
+		{name: "max duration only", deadline: now.Add(time.Hour), want: func(d time.Time) bool { return d.Equal(now.Add(time.Hour)) }},
+		{name: "write timeout only", writeTimeout: time.Minute, want: func(d time.Time) bool { return d.After(now) && d.Before(now.Add(2*time.Minute)) }},
+		{name: "max duration is earlier", writeTimeout: time.Minute, deadline: now.Add(time.Second), want: func(d time.Time) bool { return d.Equal(now.Add(time.Second)) }},
+		{name: "write timeout is earlier", writeTimeout: time.Second, deadline: now.Add(time.Hour), want: func(d time.Time) bool { return d.Before(now.Add(time.Minute)) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Plugin{cfg: &Config{SlowClient: &SlowClientConfig{WriteTimeout: tt.writeTimeout}}}
+			assert.True(t, tt.want(p.writeDeadline(tt.deadline)))
+		})
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *transfer)
+		abort bool
+	}{
+		{name: "not verified", setup: func(*transfer) {}},
+		{name: "checksum", setup: func(tr *transfer) { tr.checksum = &checksum{algo: checksumSHA256, h: sha256.New()} }, abort: true},
+		{name: "digest", setup: func(tr *transfer) { tr.digest = sha256.New() }, abort: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPlugin(t, &Config{})
+			tr := newTransfer("http://upstream/f", http.StatusOK)
+			tt.setup(tr)
+
+			body := io.MultiReader(strings.NewReader("hello"), iotest.ErrReader(io.ErrUnexpectedEOF))
+			stream := func() {
+				p.stream(context.Background(), httptest.NewRecorder(), body, make([]byte, 32), tr)
+			}
+
+			if tt.abort {
+				assert.PanicsWithValue(t, http.ErrAbortHandler, stream)
+			} else {
+				assert.NotPanics(t, stream)
+			}
+
+			assert.Equal(t, outcomeTruncated, tr.outcome)
+			assert.Equal(t, int64(5), tr.written)
+		})
+	}
+}
+
+// the client must not get a complete response with the digest of the truncated body
+func TestTruncatedDigest(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer up.Close()
+
+	p := newTestPlugin(t, &Config{Digest: true})
+	front := httptest.NewServer(p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set(xSendRemoteHeader, up.URL)
+	})))
+	defer front.Close()
+
+	resp, err := http.Get(front.URL) //nolint:noctx
+	require.NoError(t, err)
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	_, err = io.ReadAll(resp.Body)
+	assert.Error(t, err)
+	assert.Empty(t, resp.Trailer.Get(contentDigestHeader))
+}
@@ -51,7 +51,7 @@ require (
 	github.com/quic-go/qpack v0.4.0 // indirect
 	github.com/quic-go/quic-go v0.43.0 // indirect
 	github.com/roadrunner-server/api/v4 v4.12.0 // indirect
-	github.com/roadrunner-server/errors v1.4.0 // indirect
+	github.com/roadrunner-server/errors v1.4.1 // indirect
 	github.com/roadrunner-server/goridge/v3 v3.8.2 // indirect
 	github.com/roadrunner-server/sdk/v4 v4.7.1 // indirect
 	github.com/roadrunner-server/tcplisten v1.4.0 // indirect
@@ -82,6 +82,7 @@ require (
 	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/sys v0.19.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
+	golang.org/x/time v0.5.0 // indirect
 	golang.org/x/tools v0.20.0 // indirect
 	google.golang.org/protobuf v1.34.0 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
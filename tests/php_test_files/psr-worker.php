@@ -22,6 +22,14 @@ while ($req = $psr7->waitRequest()) {
                 $resp = new Response(200, ["X-Sendremotefile" => "http://127.0.0.1:18953/file", "Content-Disposition" => "attachment; filename=1MB.jpg"]);
                 break;
 
+            case "/remote-file-fallback":
+                $resp = new Response(200, ["X-Sendremotefile" => "http://127.0.0.1:18954/file", "X-Sendremotefile-Fallback" => "http://127.0.0.1:18953/file"]);
+                break;
+
+            case "/remote-file-inline":
+                $resp = new Response(200, ["X-Sendremotefile" => "http://127.0.0.1:18953/file", "X-Sendremotefile-Content-Type" => "upstream", "X-Sendremotefile-Disposition" => "inline", "X-Sendremotefile-Filename" => "фото.jpg"]);
+                break;
+
             case "/remote-file-not-found":
                 $resp = new Response(200, ["X-Sendremotefile" => "http://127.0.0.1:18953/file-missing"]);
                 break;
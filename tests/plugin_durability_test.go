@@ -103,13 +103,13 @@ func TestStorageDown(t *testing.T) {
 	r, err := http.DefaultClient.Get("http://127.0.0.1:18953/minio-file")
 	require.NoError(t, err)
 
-	assert.Equal(t, 500, r.StatusCode)
+	assert.Equal(t, 502, r.StatusCode)
 	assert.Equal(t, 1, oLogger.FilterMessageSnippet("failed to request from the upstream").Len())
 
 	b, err := io.ReadAll(r.Body)
 	require.NoError(t, err)
 
-	assert.Equal(t, "Internal Server Error\n", string(b))
+	assert.Equal(t, "Bad Gateway\n", string(b))
 
 	err = r.Body.Close()
 	require.NoError(t, err)
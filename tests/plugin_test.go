@@ -229,6 +229,8 @@ func TestSendremotefileFileStream(t *testing.T) {
 	time.Sleep(time.Second)
 	t.Run("remoteFileCheck", remoteFileCheck)
 	t.Run("localFileCheck", localFileCheck(oLogger))
+	t.Run("remoteFileFallbackCheck", remoteFileFallbackCheck(oLogger))
+	t.Run("remoteFileInlineCheck", remoteFileInlineCheck)
 	t.Run("remoteFileNotFoundCheck", remoteFileNotFoundCheck(oLogger))
 	t.Run("remoteFileTimeoutCheck", remoteFileTimeoutCheck(oLogger))
 
@@ -259,6 +261,44 @@ func remoteFileCheck(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func remoteFileFallbackCheck(oLogger *mocklogger.ObservedLogs) func(t *testing.T) {
+	return func(t *testing.T) {
+		r, err := http.DefaultClient.Get("http://127.0.0.1:18953/remote-file-fallback")
+		require.NoError(t, err)
+
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		fs, err := os.Stat("./data/1MB.jpg")
+		require.NoError(t, err)
+
+		assert.Equal(t, int(fs.Size()), len(b))
+		assert.Equal(t, 200, r.StatusCode)
+		assert.Equal(t, "", r.Header.Get("X-Sendremotefile"))
+		assert.Equal(t, "", r.Header.Get("X-Sendremotefile-Fallback"))
+		assert.Equal(t, 1, oLogger.FilterMessageSnippet("serving from the fallback upstream").Len())
+
+		err = r.Body.Close()
+		require.NoError(t, err)
+	}
+}
+
+func remoteFileInlineCheck(t *testing.T) {
+	r, err := http.DefaultClient.Get("http://127.0.0.1:18953/remote-file-inline")
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(r.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, 200, r.StatusCode)
+	assert.Equal(t, "image/jpeg", r.Header.Get("Content-Type"))
+	assert.Equal(t, `inline; filename="____.jpg"; filename*=UTF-8''%D1%84%D0%BE%D1%82%D0%BE.jpg`, r.Header.Get("Content-Disposition"))
+	assert.Equal(t, "", r.Header.Get("X-Sendremotefile-Filename"))
+
+	err = r.Body.Close()
+	require.NoError(t, err)
+}
+
 func localFileCheck(oLogger *mocklogger.ObservedLogs) func(t *testing.T) {
 	return func(t *testing.T) {
 		r, err := http.DefaultClient.Get("http://127.0.0.1:18953/local-file")
@@ -267,7 +307,7 @@ func localFileCheck(oLogger *mocklogger.ObservedLogs) func(t *testing.T) {
 
 		assert.Equal(t, 404, r.StatusCode)
 		assert.Equal(t, "", r.Header.Get("X-Sendremotefile"))
-		assert.Equal(t, 1, oLogger.FilterMessageSnippet("header value must start with http").Len())
+		assert.Equal(t, 1, oLogger.FilterMessageSnippet("invalid upstream URL").Len())
 
 		err = r.Body.Close()
 		require.NoError(t, err)
@@ -280,7 +320,7 @@ func remoteFileNotFoundCheck(oLogger *mocklogger.ObservedLogs) func(t *testing.T
 		require.NoError(t, err)
 		defer r.Body.Close()
 
-		assert.Equal(t, 400, r.StatusCode)
+		assert.Equal(t, 404, r.StatusCode)
 		assert.Equal(t, "", r.Header.Get("X-Sendremotefile"))
 		assert.Equal(t, 1, oLogger.FilterMessageSnippet("invalid upstream response status code").Len())
 
@@ -294,7 +334,7 @@ func remoteFileTimeoutCheck(oLogger *mocklogger.ObservedLogs) func(t *testing.T)
 		r, err := http.DefaultClient.Get("http://127.0.0.1:18953/remote-file-timeout")
 		require.NoError(t, err)
 
-		assert.Equal(t, 408, r.StatusCode)
+		assert.Equal(t, 504, r.StatusCode)
 		assert.Equal(t, "", r.Header.Get("X-Sendremotefile"))
 
 		err = r.Body.Close()
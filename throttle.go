@@ -0,0 +1,29 @@
+package sendremotefile
+
+import (
+	"golang.org/x/time/rate"
+)
+
+// minBurst keeps the chunks reasonably large for the low rates
+const minBurst int = 16 * 1024
+
+// newRateLimiter returns a token bucket limiter for the bytes/sec rate, nil for the unlimited rate
+func newRateLimiter(bps int64) *rate.Limiter {
+	if bps <= 0 {
+		return nil
+	}
+
+	return rate.NewLimiter(rate.Limit(bps), max(int(bps), minBurst))
+}
+
+// transferRate returns the per-transfer rate, the worker can only lower the configured one
+func transferRate(configured int64, requested int64) int64 {
+	switch {
+	case requested <= 0:
+		return configured
+	case configured <= 0:
+		return requested
+	default:
+		return min(configured, requested)
+	}
+}
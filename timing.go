@@ -0,0 +1,70 @@
+package sendremotefile
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	serverTimingHeader     string = "Server-Timing"
+	trailerHeader          string = "Trailer"
+	xSendRemoteCacheHeader string = "X-Sendremotefile-Cache"
+	// there is no cache in front of the upstream, every transfer is a miss
+	cacheMiss string = "MISS"
+)
+
+// withConnectTrace measures the time spent to get a connection to the upstream (DNS, dial, TLS) for the single attempt,
+// the hedged requests run concurrently and are timed on their own
+func withConnectTrace(ctx context.Context, a *attempt) context.Context {
+	var getConn time.Time
+
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) {
+			getConn = time.Now()
+		},
+		GotConn: func(httptrace.GotConnInfo) {
+			if !getConn.IsZero() {
+				a.connect += time.Since(getConn)
+			}
+		},
+	})
+}
+
+// setServerTiming adds the Server-Timing and the cache status headers. When the body is streamed,
+// the total duration is declared as a trailer and sent by the setServerTimingTrailer.
+func setServerTiming(h http.Header, t *transfer, streamed bool) {
+	metrics := []string{
+		timingMetric("worker", t.worker),
+		timingMetric("upstream-connect", t.connect),
+	}
+
+	if t.upstreamStatus > 0 {
+		metrics = append(metrics, timingMetric("upstream-ttfb", t.ttfb))
+	}
+
+	metrics = append(metrics, "cache;desc="+cacheMiss)
+
+	if !streamed {
+		metrics = append(metrics, timingMetric("total", t.duration()))
+	}
+
+	h.Set(serverTimingHeader, strings.Join(metrics, ", "))
+	h.Set(xSendRemoteCacheHeader, cacheMiss)
+
+	if streamed {
+		h.Add(trailerHeader, serverTimingHeader)
+	}
+}
+
+// setServerTimingTrailer sets the trailer value, should be called after the body is written
+func setServerTimingTrailer(h http.Header, t *transfer) {
+	h.Set(serverTimingHeader, timingMetric("total", t.duration()))
+}
+
+func timingMetric(name string, d time.Duration) string {
+	return name + ";dur=" + strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
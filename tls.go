@@ -0,0 +1,176 @@
+package sendremotefile
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfigs builds the per upstream host TLS configs, the files are loaded once (client certificates are reloaded
+// when changed)
+func newTLSConfigs(cfg map[string]*TLSConfig, log *zap.Logger) (map[string]*tls.Config, error) {
+	configs := make(map[string]*tls.Config, len(cfg))
+	for host, c := range cfg {
+		if c == nil {
+			continue
+		}
+
+		tc, err := c.build(log)
+		if err != nil {
+			return nil, fmt.Errorf("tls.%s: %w", host, err)
+		}
+
+		if c.InsecureSkipVerify {
+			log.Warn("the upstream TLS certificate verification is disabled, use it for development only", zap.String("host", host))
+		}
+
+		configs[host] = tc
+	}
+
+	return configs, nil
+}
+
+func (c *TLSConfig) build(log *zap.Logger) (*tls.Config, error) {
+	tc := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify, //nolint:gosec
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if c.MinVersion != "" {
+		tc.MinVersion = tlsVersions[c.MinVersion]
+	}
+
+	suites, err := cipherSuites(c.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	tc.CipherSuites = suites
+
+	if len(c.RootCA) > 0 {
+		tc.RootCAs = x509.NewCertPool()
+		for _, f := range c.RootCA {
+			pem, err := os.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+
+			if !tc.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in the root CA file %q", f)
+			}
+		}
+	}
+
+	if c.Cert != "" {
+		r := &certReloader{certFile: c.Cert, keyFile: c.Key, log: log}
+		// fail fast on the invalid pair
+		if err := r.load(); err != nil {
+			return nil, err
+		}
+		tc.GetClientCertificate = r.GetClientCertificate
+	}
+
+	return tc, nil
+}
+
+// cipherSuites returns the IDs of the TLS 1.2 cipher suites, only the secure ones are accepted
+func cipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16, len(tls.CipherSuites()))
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, n := range names {
+		id, ok := known[n]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", n)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// certReloader loads the client certificate again when the certificate or the key file changes
+type certReloader struct {
+	certFile string
+	keyFile  string
+	log      *zap.Logger
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+func (r *certReloader) load() error {
+	certMod, keyMod, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.cert, r.certMod, r.keyMod = &cert, certMod, keyMod
+
+	return nil
+}
+
+func (r *certReloader) modTimes() (time.Time, time.Time, error) {
+	cs, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	ks, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	return cs.ModTime(), ks.ModTime(), nil
+}
+
+// GetClientCertificate returns the current certificate, the previous one is used when the new files can't be loaded
+// (e.g. only one of them is replaced yet)
+func (r *certReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	certMod, keyMod, err := r.modTimes()
+	if err == nil && certMod.Equal(r.certMod) && keyMod.Equal(r.keyMod) {
+		return r.cert, nil
+	}
+
+	if err == nil {
+		err = r.load()
+	}
+
+	if err != nil {
+		r.log.Error("failed to reload the client certificate, using the previous one", zap.String("cert", r.certFile), zap.Error(err))
+		return r.cert, nil
+	}
+
+	r.log.Info("client certificate reloaded", zap.String("cert", r.certFile))
+
+	return r.cert, nil
+}
@@ -0,0 +1,137 @@
+package sendremotefile
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// writeCert writes a self-signed certificate and its key with the common name, the files get the mod time
+func writeCert(t *testing.T, certFile, keyFile, cn string, mod time.Time) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	require.NoError(t, os.Chtimes(certFile, mod, mod))
+	require.NoError(t, os.Chtimes(keyFile, mod, mod))
+}
+
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+
+	c, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+
+	return c.Subject.CommonName
+}
+
+func TestCertReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name string
+		// change modifies the files before the handshake
+		change func(t *testing.T, step time.Time)
+		cn     string
+	}{
+		{name: "unchanged", change: func(*testing.T, time.Time) {}, cn: "first"},
+		{
+			name:   "replaced",
+			change: func(t *testing.T, step time.Time) { writeCert(t, certFile, keyFile, "second", step) },
+			cn:     "second",
+		},
+		{
+			name: "only the cert replaced",
+			change: func(t *testing.T, step time.Time) {
+				writeCert(t, certFile, filepath.Join(dir, "other.pem"), "third", step)
+			},
+			cn: "second",
+		},
+		{
+			name:   "key removed",
+			change: func(t *testing.T, _ time.Time) { require.NoError(t, os.Remove(keyFile)) },
+			cn:     "second",
+		},
+		{
+			name:   "pair restored",
+			change: func(t *testing.T, step time.Time) { writeCert(t, certFile, keyFile, "fourth", step) },
+			cn:     "fourth",
+		},
+	}
+
+	writeCert(t, certFile, keyFile, "first", start)
+	tc, err := (&TLSConfig{Cert: certFile, Key: keyFile}).build(zap.NewNop())
+	require.NoError(t, err)
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change(t, start.Add(time.Duration(i+1)*time.Minute))
+
+			cert, err := tc.GetClientCertificate(&tls.CertificateRequestInfo{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.cn, commonName(t, cert))
+		})
+	}
+}
+
+func TestTLSConfigBuild(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "ca", time.Now())
+
+	tests := []struct {
+		name string
+		cfg  *TLSConfig
+		err  bool
+	}{
+		{name: "defaults", cfg: &TLSConfig{}},
+		{name: "root ca", cfg: &TLSConfig{RootCA: []string{certFile}, MinVersion: "1.3"}},
+		{name: "root ca without certificates", cfg: &TLSConfig{RootCA: []string{keyFile}}, err: true},
+		{name: "missing root ca", cfg: &TLSConfig{RootCA: []string{filepath.Join(dir, "none.pem")}}, err: true},
+		{name: "cipher suite", cfg: &TLSConfig{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}},
+		{name: "insecure cipher suite", cfg: &TLSConfig{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, err: true},
+		{name: "mismatched pair", cfg: &TLSConfig{Cert: certFile, Key: certFile}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.cfg.build(zap.NewNop())
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
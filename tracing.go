@@ -0,0 +1,44 @@
+package sendremotefile
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const spanName string = "sendremotefile.upstream_fetch"
+
+// startSpan starts a child span of the incoming request span for the upstream fetch.
+// When the http plugin has no tracing configured, the span is a no-op.
+func startSpan(r *http.Request, upstream string) (context.Context, trace.Span) {
+	tp := trace.SpanFromContext(r.Context()).TracerProvider()
+
+	return tp.Tracer(pluginName).Start(r.Context(), spanName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("server.address", upstreamHost(upstream))),
+	)
+}
+
+// endSpan records the transfer results and ends the span
+func endSpan(span trace.Span, t *transfer) {
+	span.SetAttributes(
+		// the URL actually used, might be a mirror
+		attribute.String("server.address", upstreamHost(t.url)),
+		attribute.Int64("sendremotefile.bytes", t.written),
+		attribute.Int("sendremotefile.attempts", t.attempts),
+		attribute.String("sendremotefile.outcome", t.outcome),
+	)
+
+	if t.upstreamStatus > 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", t.upstreamStatus))
+	}
+
+	if t.outcome != outcomeServed {
+		span.SetStatus(codes.Error, t.outcome)
+	}
+
+	span.End()
+}
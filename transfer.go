@@ -0,0 +1,84 @@
+package sendremotefile
+
+import (
+	"hash"
+	"math/rand/v2"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// transfer holds the state of a single remote file transfer
+type transfer struct {
+	requestID      string
+	url            string
+	start          time.Time
+	worker         time.Duration
+	connect        time.Duration
+	ttfb           time.Duration
+	workerStatus   int
+	upstreamStatus int
+	attempts       int
+	expected       int64
+	written        int64
+	outcome        string
+	// rate limits the bandwidth of this transfer, nil is unlimited
+	rate *rate.Limiter
+	// checksum verifies the streamed body, nil is not verified
+	checksum *checksum
+	// digest computes the Content-Digest trailer, nil is not computed
+	digest hash.Hash
+}
+
+func newTransfer(url string, workerStatus int) *transfer {
+	return &transfer{
+		url:          url,
+		start:        time.Now(),
+		workerStatus: workerStatus,
+		expected:     -1,
+		// the transfer is treated as served unless the middleware says otherwise
+		outcome: outcomeServed,
+	}
+}
+
+func (t *transfer) duration() time.Duration {
+	return time.Since(t.start)
+}
+
+// logTransfer writes the summary entry of the finished transfer, if enabled and sampled
+func (p *Plugin) logTransfer(t *transfer) {
+	if !p.cfg.TransferLog.Enabled {
+		return
+	}
+
+	if p.cfg.TransferLog.SampleRate < 1 && rand.Float64() >= p.cfg.TransferLog.SampleRate { //nolint:gosec
+		return
+	}
+
+	d := t.duration()
+
+	var throughput float64
+	if d > 0 {
+		throughput = float64(t.written) / d.Seconds()
+	}
+
+	var retries int
+	if t.attempts > 1 {
+		retries = t.attempts - 1
+	}
+
+	p.log.Info("transfer finished",
+		zap.String("request_id", t.requestID),
+		zap.String("upstream", p.redact.hostPath(t.url)),
+		zap.Int("worker_status", t.workerStatus),
+		zap.Int("upstream_status", t.upstreamStatus),
+		zap.Int64("bytes_sent", t.written),
+		zap.Int64("expected_length", t.expected),
+		zap.Duration("ttfb", t.ttfb),
+		zap.Duration("duration", d),
+		zap.Float64("throughput_bytes_per_sec", throughput),
+		zap.Int("retries", retries),
+		zap.String("reason", t.outcome),
+	)
+}
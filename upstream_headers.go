@@ -0,0 +1,157 @@
+package sendremotefile
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// xSendRemoteUpstreamPrefix is the prefix of the worker headers forwarded to the upstream,
+	// e.g. X-Sendremotefile-Upstream-Authorization
+	xSendRemoteUpstreamPrefix string = "X-Sendremotefile-Upstream-"
+	// anyHost is the upstream_headers key applied to all hosts
+	anyHost               string = "*"
+	forwardedHeader       string = "Forwarded"
+	xForwardedForHeader   string = "X-Forwarded-For"
+	xForwardedProtoHeader string = "X-Forwarded-Proto"
+	xForwardedHostHeader  string = "X-Forwarded-Host"
+	contentEncodingHeader string = "Content-Encoding"
+	varyHeader            string = "Vary"
+)
+
+// workerUpstreamHeaders moves the allowlisted X-Sendremotefile-Upstream-* headers to the upstream headers.
+// All such headers are removed from the worker response, the not allowed ones are logged.
+func (p *Plugin) workerUpstreamHeaders(h http.Header, upstream http.Header) {
+	for k, v := range h {
+		if !strings.HasPrefix(k, xSendRemoteUpstreamPrefix) {
+			continue
+		}
+
+		h.Del(k)
+
+		name := http.CanonicalHeaderKey(strings.TrimPrefix(k, xSendRemoteUpstreamPrefix))
+		if _, ok := p.forwardWorkerHeaders[name]; !ok {
+			p.log.Warn("upstream header is not allowed, ignoring", zap.String("header", name))
+			continue
+		}
+
+		for _, val := range v {
+			if !validHeader(name, val) {
+				p.log.Warn("invalid upstream header value, ignoring", zap.String("header", name))
+				continue
+			}
+
+			upstream.Add(name, val)
+		}
+	}
+}
+
+// clientHeaders returns the allowlisted client request headers and the forwarding headers, if enabled
+func (p *Plugin) clientHeaders(r *http.Request) http.Header {
+	h := make(http.Header, len(p.cfg.ForwardRequestHeaders)+4)
+	for _, k := range p.cfg.ForwardRequestHeaders {
+		if v := r.Header.Values(k); len(v) > 0 {
+			h[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+
+	if !p.cfg.ForwardingHeaders {
+		return h
+	}
+
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	xff := ip
+	if prior := r.Header.Values(xForwardedForHeader); len(prior) > 0 {
+		xff = strings.Join(prior, ", ") + ", " + ip
+	}
+
+	// RFC 7239, the IPv6 addresses are quoted and bracketed
+	forNode := ip
+	if strings.Contains(ip, ":") {
+		forNode = `"[` + ip + `]"`
+	}
+
+	forwarded := "for=" + forNode + ";host=" + strconv.Quote(r.Host) + ";proto=" + proto
+	if prior := r.Header.Values(forwardedHeader); len(prior) > 0 {
+		forwarded = strings.Join(prior, ", ") + ", " + forwarded
+	}
+
+	h.Set(forwardedHeader, forwarded)
+	h.Set(xForwardedForHeader, xff)
+	h.Set(xForwardedProtoHeader, proto)
+	h.Set(xForwardedHostHeader, r.Host)
+
+	return h
+}
+
+// lookupHost returns the per-host config entry for the `host` or `host:port`, the `host:port` key
+// takes precedence over the `host` key, `*` is the default
+func lookupHost[T any](m map[string]T, host string) T {
+	// the config keys are case-insensitive
+	host = strings.ToLower(host)
+	if v, ok := m[host]; ok {
+		return v
+	}
+
+	// the host without the port
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		if v, ok := m[hostname]; ok {
+			return v
+		}
+	}
+
+	return m[anyHost]
+}
+
+// optionHeaders sets the allowlisted X-Sendremotefile-Options headers on the upstream headers,
+// the not allowed ones are logged
+func (p *Plugin) optionHeaders(h map[string]string, upstream http.Header) {
+	for k, v := range h {
+		name := http.CanonicalHeaderKey(k)
+		if _, ok := p.forwardWorkerHeaders[name]; !ok {
+			p.log.Warn("upstream header is not allowed, ignoring", zap.String("header", name))
+			continue
+		}
+
+		// already validated
+		upstream.Set(name, v)
+	}
+}
+
+// upstreamHeaders returns the headers for the upstream request: the forwarded client headers,
+// overridden by the configured ones for the URL host (or `*`), overridden by the worker ones
+func (p *Plugin) upstreamHeaders(url string, d *directives) http.Header {
+	static := lookupHost(p.cfg.UpstreamHeaders, upstreamHost(url))
+
+	if len(static) == 0 && len(d.clientHeaders) == 0 {
+		return d.headers
+	}
+
+	h := make(http.Header, len(d.clientHeaders)+len(static)+len(d.headers))
+	for k, v := range d.clientHeaders {
+		h[k] = v
+	}
+
+	for k, v := range static {
+		h.Set(k, v)
+	}
+
+	for k, v := range d.headers {
+		h[k] = v
+	}
+
+	return h
+}
@@ -0,0 +1,90 @@
+package sendremotefile
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		forward    []string
+		forwarding bool
+		remoteAddr string
+		tls        bool
+		header     http.Header
+		want       http.Header
+	}{
+		{
+			name:       "allowlist only",
+			forward:    []string{"accept-language"},
+			remoteAddr: "192.0.2.1:1234",
+			header:     http.Header{"Accept-Language": {"en"}, "Cookie": {"c"}},
+			want:       http.Header{"Accept-Language": {"en"}},
+		},
+		{
+			name:       "forwarding",
+			forwarding: true,
+			remoteAddr: "192.0.2.1:1234",
+			want: http.Header{
+				forwardedHeader:       {`for=192.0.2.1;host="example.com";proto=http`},
+				xForwardedForHeader:   {"192.0.2.1"},
+				xForwardedProtoHeader: {"http"},
+				xForwardedHostHeader:  {"example.com"},
+			},
+		},
+		{
+			name:       "appended to the prior proxies",
+			forwarding: true,
+			remoteAddr: "192.0.2.1:1234",
+			header:     http.Header{xForwardedForHeader: {"198.51.100.1, 198.51.100.2"}, forwardedHeader: {"for=198.51.100.1"}},
+			want: http.Header{
+				forwardedHeader:       {`for=198.51.100.1, for=192.0.2.1;host="example.com";proto=http`},
+				xForwardedForHeader:   {"198.51.100.1, 198.51.100.2, 192.0.2.1"},
+				xForwardedProtoHeader: {"http"},
+				xForwardedHostHeader:  {"example.com"},
+			},
+		},
+		{
+			name:       "ipv6 over tls",
+			forwarding: true,
+			remoteAddr: "[2001:db8::1]:1234",
+			tls:        true,
+			want: http.Header{
+				forwardedHeader:       {`for="[2001:db8::1]";host="example.com";proto=https`},
+				xForwardedForHeader:   {"2001:db8::1"},
+				xForwardedProtoHeader: {"https"},
+				xForwardedHostHeader:  {"example.com"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPlugin(t, &Config{ForwardRequestHeaders: tt.forward, ForwardingHeaders: tt.forwarding})
+
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+
+			assert.Equal(t, tt.want, p.clientHeaders(r))
+		})
+	}
+}
+
+func TestForwardRequestHostRejected(t *testing.T) {
+	for _, h := range []string{"Host", "host"} {
+		cfg := &Config{ForwardRequestHeaders: []string{"Accept-Language", h}}
+		cfg.InitDefaults()
+		assert.Error(t, cfg.Valid())
+	}
+}